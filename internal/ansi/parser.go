@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/rizkyandriawan/eddie/internal/config"
 )
 
 // Segment represents a piece of text with styling
@@ -24,26 +26,6 @@ type Screen struct {
 	Height int
 }
 
-// Default ANSI colors (standard 16 colors)
-var defaultColors = []color.RGBA{
-	{0, 0, 0, 255},       // 0: Black
-	{205, 49, 49, 255},   // 1: Red
-	{13, 188, 121, 255},  // 2: Green
-	{229, 229, 16, 255},  // 3: Yellow
-	{36, 114, 200, 255},  // 4: Blue
-	{188, 63, 188, 255},  // 5: Magenta
-	{17, 168, 205, 255},  // 6: Cyan
-	{229, 229, 229, 255}, // 7: White
-	{102, 102, 102, 255}, // 8: Bright Black
-	{241, 76, 76, 255},   // 9: Bright Red
-	{35, 209, 139, 255},  // 10: Bright Green
-	{245, 245, 67, 255},  // 11: Bright Yellow
-	{59, 142, 234, 255},  // 12: Bright Blue
-	{214, 112, 214, 255}, // 13: Bright Magenta
-	{41, 184, 219, 255},  // 14: Bright Cyan
-	{255, 255, 255, 255}, // 15: Bright White
-}
-
 // ANSI escape sequence regex
 var ansiRegex = regexp.MustCompile(`\x1b\[([0-9;]*)([A-Za-z])`)
 
@@ -55,6 +37,7 @@ var otherEscRegex = regexp.MustCompile(`\x1b\][^\x07]*\x07?`)
 
 // Parser parses ANSI escape sequences
 type Parser struct {
+	palette   config.Palette
 	defaultFg color.RGBA
 	defaultBg color.RGBA
 	fg        color.RGBA
@@ -64,9 +47,10 @@ type Parser struct {
 	underline bool
 }
 
-// NewParser creates a new ANSI parser
+// NewParser creates a new ANSI parser using the default palette
 func NewParser(fg, bg color.RGBA) *Parser {
 	return &Parser{
+		palette:   config.Palette{ANSI: config.DefaultANSI},
 		defaultFg: fg,
 		defaultBg: bg,
 		fg:        fg,
@@ -74,6 +58,17 @@ func NewParser(fg, bg color.RGBA) *Parser {
 	}
 }
 
+// NewParserWithPalette creates a new ANSI parser using the colors of a theme palette
+func NewParserWithPalette(palette config.Palette) *Parser {
+	return &Parser{
+		palette:   palette,
+		defaultFg: palette.Foreground,
+		defaultBg: palette.Background,
+		fg:        palette.Foreground,
+		bg:        palette.Background,
+	}
+}
+
 // Parse parses ANSI text into segments
 func (p *Parser) Parse(text string) []Segment {
 	// Pre-process: remove OSC sequences (terminal title, etc.)
@@ -171,9 +166,9 @@ func (p *Parser) processEscape(seq string) {
 		case code == 24:
 			p.underline = false
 		case code >= 30 && code <= 37:
-			p.fg = defaultColors[code-30]
+			p.fg = p.palette.ANSI[code-30]
 			if p.bold {
-				p.fg = defaultColors[code-30+8]
+				p.fg = p.palette.ANSI[code-30+8]
 			}
 		case code == 38:
 			// Extended foreground color
@@ -182,7 +177,7 @@ func (p *Parser) processEscape(seq string) {
 				if next == 5 && i+2 < len(codes) {
 					// 256 color mode
 					colorIdx, _ := strconv.Atoi(codes[i+2])
-					p.fg = get256Color(colorIdx)
+					p.fg = p.palette.Color256(colorIdx)
 					i += 2
 				} else if next == 2 && i+4 < len(codes) {
 					// RGB mode
@@ -196,14 +191,14 @@ func (p *Parser) processEscape(seq string) {
 		case code == 39:
 			p.fg = p.defaultFg
 		case code >= 40 && code <= 47:
-			p.bg = defaultColors[code-40]
+			p.bg = p.palette.ANSI[code-40]
 		case code == 48:
 			// Extended background color
 			if i+1 < len(codes) {
 				next, _ := strconv.Atoi(codes[i+1])
 				if next == 5 && i+2 < len(codes) {
 					colorIdx, _ := strconv.Atoi(codes[i+2])
-					p.bg = get256Color(colorIdx)
+					p.bg = p.palette.Color256(colorIdx)
 					i += 2
 				} else if next == 2 && i+4 < len(codes) {
 					r, _ := strconv.Atoi(codes[i+2])
@@ -216,9 +211,9 @@ func (p *Parser) processEscape(seq string) {
 		case code == 49:
 			p.bg = p.defaultBg
 		case code >= 90 && code <= 97:
-			p.fg = defaultColors[code-90+8]
+			p.fg = p.palette.ANSI[code-90+8]
 		case code >= 100 && code <= 107:
-			p.bg = defaultColors[code-100+8]
+			p.bg = p.palette.ANSI[code-100+8]
 		}
 	}
 }
@@ -231,24 +226,6 @@ func (p *Parser) reset() {
 	p.underline = false
 }
 
-// get256Color returns a color from the 256-color palette
-func get256Color(idx int) color.RGBA {
-	if idx < 16 {
-		return defaultColors[idx]
-	} else if idx < 232 {
-		// 216 colors (6x6x6 cube)
-		idx -= 16
-		r := uint8((idx / 36) * 51)
-		g := uint8(((idx / 6) % 6) * 51)
-		b := uint8((idx % 6) * 51)
-		return color.RGBA{r, g, b, 255}
-	} else {
-		// 24 grayscale colors
-		gray := uint8((idx-232)*10 + 8)
-		return color.RGBA{gray, gray, gray, 255}
-	}
-}
-
 // StripANSI removes all ANSI escape sequences from text
 func StripANSI(text string) string {
 	text = stripOSC(text)
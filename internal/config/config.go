@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,6 +13,9 @@ import (
 type Config struct {
 	Output   string    `yaml:"output"`
 	Manifest bool      `yaml:"manifest"`
+	Format   Formats   `yaml:"format"` // default output formats of captures
+	SVG      SVG       `yaml:"svg"`
+	HTML     HTML      `yaml:"html"`
 	Terminal Terminal  `yaml:"terminal"`
 	Theme    Theme     `yaml:"theme"`
 	Sessions []Session `yaml:"sessions"`
@@ -21,13 +27,46 @@ type Terminal struct {
 }
 
 type Theme struct {
-	Name       string            `yaml:"name"`
-	Background string            `yaml:"background"`
-	Foreground string            `yaml:"foreground"`
-	Font       string            `yaml:"font"`
-	FontSize   float64           `yaml:"font_size"`
-	Padding    int               `yaml:"padding"`
-	Colors     map[string]string `yaml:"colors"`
+	Name          string            `yaml:"name"`
+	Import        string            `yaml:"import"`     // color scheme file (iTerm2, Alacritty, Windows Terminal or base16)
+	Background    string            `yaml:"background"` // hex color, or transparent
+	Foreground    string            `yaml:"foreground"`
+	Font          string            `yaml:"font"`           // family name or file path, the bundled font if empty
+	FontStyle     string            `yaml:"font_style"`     // style of the family used for regular text, e.g. Medium
+	FontFallbacks []string          `yaml:"font_fallbacks"` // fonts for glyphs missing from the primary font
+	FontSize      float64           `yaml:"font_size"`
+	LineHeight    float64           `yaml:"line_height"` // line spacing as a multiple of the font's line height
+	Scale         float64           `yaml:"scale"`       // device pixel ratio of raster images
+	Padding       int               `yaml:"padding"`
+	LinkUnderline bool              `yaml:"link_underline"` // underline OSC 8 hyperlinks in PNG, GIF and SVG output
+	Colors        map[string]string `yaml:"colors"`
+	Cursor        Cursor            `yaml:"cursor"`
+	Window        Window            `yaml:"window"`
+}
+
+type Cursor struct {
+	Style   string `yaml:"style"`   // block, bar or underline
+	Color   string `yaml:"color"`   // defaults to the foreground color
+	Visible *bool  `yaml:"visible"` // overrides the visibility set by the program
+}
+
+type Window struct {
+	Style          string   `yaml:"style"`  // none, macos, windows or minimal
+	Title          string   `yaml:"title"`  // defaults to the program's title or session description
+	Radius         float64  `yaml:"radius"` // corner radius
+	Shadow         bool     `yaml:"shadow"`
+	Margin         int      `yaml:"margin"` // space around the window
+	MarginColor    string   `yaml:"margin_color"`
+	MarginGradient []string `yaml:"margin_gradient"` // colors from top left to bottom right
+	MarginImage    string   `yaml:"margin_image"`    // PNG or JPEG file scaled to cover the image
+}
+
+type SVG struct {
+	EmbedFont bool `yaml:"embed_font"` // embed a subset of the font used by the capture
+}
+
+type HTML struct {
+	Style string `yaml:"style"` // inline, or class to use a generated CSS file
 }
 
 type Session struct {
@@ -37,16 +76,195 @@ type Session struct {
 	Command     string   `yaml:"command"`
 	Setup       []string `yaml:"setup"`
 	Prompts     []Prompt `yaml:"prompts"`
+	Animate     Animate  `yaml:"animate"` // record the whole session as an animated GIF
+	Cast        Cast     `yaml:"cast"`    // record the whole session as an asciinema cast
+}
+
+// Animate configures the animated GIF of a session, written as
+// "animate: true" or as a mapping
+type Animate struct {
+	Enabled bool `yaml:"enabled"`
+	Delay   int  `yaml:"delay"`  // ms between frames
+	Typing  int  `yaml:"typing"` // ms between typed characters of an input
+	Loop    int  `yaml:"loop"`   // 0 loops forever, -1 plays once, n repeats n times
+}
+
+// UnmarshalYAML accepts both "animate: true" and a mapping of options
+func (a *Animate) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&a.Enabled)
+	}
+	type plain Animate
+	p := plain(*a)
+	p.Enabled = true
+	if err := value.Decode(&p); err != nil {
+		return err
+	}
+	*a = Animate(p)
+	return nil
+}
+
+// Cast configures the asciinema v2 recording of a session, written as
+// "cast: true" or as a mapping
+type Cast struct {
+	Enabled bool `yaml:"enabled"`
+	Input   bool `yaml:"input"` // also record the input sent to the program
+}
+
+// UnmarshalYAML accepts both "cast: true" and a mapping of options
+func (c *Cast) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&c.Enabled)
+	}
+	type plain Cast
+	p := plain(*c)
+	p.Enabled = true
+	if err := value.Decode(&p); err != nil {
+		return err
+	}
+	*c = Cast(p)
+	return nil
 }
 
 type Prompt struct {
-	Input       string `yaml:"input"`
-	Key         string `yaml:"key"`
-	Wait        int    `yaml:"wait"`
-	WaitUntil   string `yaml:"wait_until"`
-	Timeout     int    `yaml:"timeout"`
-	Capture     bool   `yaml:"capture"`
-	CaptureName string `yaml:"capture_name"`
+	Input             string     `yaml:"input"`
+	Key               string     `yaml:"key"`
+	Wait              int        `yaml:"wait"`
+	WaitUntil         string     `yaml:"wait_until"`
+	Timeout           int        `yaml:"timeout"`
+	Capture           bool       `yaml:"capture"`
+	CaptureName       string     `yaml:"capture_name"`
+	CaptureScrollback Scrollback `yaml:"capture_scrollback"` // include lines that scrolled off the screen
+	Format            Formats    `yaml:"format"`             // overrides the default output formats
+	Crop              *Crop      `yaml:"crop"`
+}
+
+// Crop selects the part of the screen a capture shows. Rows and columns
+// count from 1, negative ones from the end (-1 is the last).
+type Crop struct {
+	Rows []int  `yaml:"rows"` // first and last row
+	Cols []int  `yaml:"cols"` // first and last column
+	From string `yaml:"from"` // regular expression matching the first row
+	To   string `yaml:"to"`   // regular expression matching the last row, at or after the first
+	Trim bool   `yaml:"trim"` // drop blank rows at the bottom and blank columns on the right
+}
+
+// windowStyles are the supported window frame styles
+var windowStyles = map[string]bool{
+	"none":    true,
+	"macos":   true,
+	"windows": true,
+	"minimal": true,
+}
+
+// validate checks the style and margin colors of a window
+func (w *Window) validate() error {
+	if w.Style != "" && !windowStyles[strings.ToLower(w.Style)] {
+		return fmt.Errorf("style: must be none, macos, windows or minimal, got %q", w.Style)
+	}
+	if w.MarginColor != "" {
+		if _, err := ParseColor(w.MarginColor); err != nil {
+			return fmt.Errorf("margin_color: %w", err)
+		}
+	}
+	if len(w.MarginGradient) == 1 {
+		return fmt.Errorf("margin_gradient: needs at least two colors, got %d", len(w.MarginGradient))
+	}
+	for i, c := range w.MarginGradient {
+		if _, err := ParseColor(c); err != nil {
+			return fmt.Errorf("margin_gradient[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the ranges and regular expressions of a crop
+func (c *Crop) validate() error {
+	if err := validateRange(c.Rows); err != nil {
+		return fmt.Errorf("rows: %w", err)
+	}
+	if err := validateRange(c.Cols); err != nil {
+		return fmt.Errorf("cols: %w", err)
+	}
+	if _, err := regexp.Compile(c.From); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if _, err := regexp.Compile(c.To); err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	return nil
+}
+
+// validateRange checks a first and last row or column, if set
+func validateRange(r []int) error {
+	if len(r) == 0 {
+		return nil
+	}
+	if len(r) != 2 || r[0] == 0 || r[1] == 0 {
+		return fmt.Errorf("want [first, last] counted from 1, got %v", r)
+	}
+	return nil
+}
+
+// Scrollback selects the lines above the screen a capture includes,
+// written as "capture_scrollback: true" for all of them or a number of lines
+type Scrollback struct {
+	Enabled bool
+	Lines   int // most recent lines to include, 0 for all
+}
+
+// UnmarshalYAML accepts both "capture_scrollback: true" and a number of lines
+func (s *Scrollback) UnmarshalYAML(value *yaml.Node) error {
+	if err := value.Decode(&s.Enabled); err == nil {
+		s.Lines = 0
+		return nil
+	}
+	var lines int
+	if err := value.Decode(&lines); err != nil || lines < 0 {
+		return fmt.Errorf("capture_scrollback: want true, false or a number of lines, got %q", value.Value)
+	}
+	s.Enabled = lines > 0
+	s.Lines = lines
+	return nil
+}
+
+// Formats is a list of output formats, written as a single value or a list
+type Formats []string
+
+// supportedFormats are the output formats captures can be rendered to
+var supportedFormats = map[string]bool{
+	"png":  true,
+	"svg":  true,
+	"html": true,
+}
+
+// UnmarshalYAML accepts both "format: svg" and "format: [png, svg]"
+func (f *Formats) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*f = Formats{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*f = list
+	return nil
+}
+
+// validate normalizes the format names and checks they are supported
+func (f Formats) validate() error {
+	if f != nil && len(f) == 0 {
+		return fmt.Errorf("must list at least one format")
+	}
+	for i, format := range f {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if !supportedFormats[format] {
+			return fmt.Errorf("unsupported format %q", format)
+		}
+		f[i] = format
+	}
+	return nil
 }
 
 func Load(path string) (*Config, error) {
@@ -57,17 +275,27 @@ func Load(path string) (*Config, error) {
 
 	cfg := &Config{
 		Output: "./screenshots",
+		Format: Formats{"png"},
+		HTML: HTML{
+			Style: "inline",
+		},
 		Terminal: Terminal{
 			Width:  120,
 			Height: 40,
 		},
 		Theme: Theme{
 			Name:       "dark",
-			Background: "#1a1a1a",
-			Foreground: "#d4d4d4",
-			Font:       "monospace",
 			FontSize:   14,
+			LineHeight: 1,
+			Scale:      1,
 			Padding:    20,
+			Cursor: Cursor{
+				Style: "block",
+			},
+			Window: Window{
+				Style:  "none",
+				Radius: 10,
+			},
 		},
 	}
 
@@ -75,8 +303,68 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	// Fill in the colors not set explicitly from the imported scheme, then the named theme
+	if cfg.Theme.Import != "" {
+		scheme, err := importScheme(relativePath(cfg.Theme.Import, path))
+		if err != nil {
+			return nil, fmt.Errorf("theme.import: %w", err)
+		}
+		cfg.Theme.applyScheme(scheme)
+	}
+	if err := cfg.Theme.applyBuiltin(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.Theme.Palette(); err != nil {
+		return nil, err
+	}
+	if cfg.Theme.Scale <= 0 {
+		return nil, fmt.Errorf("theme.scale: must be positive, got %g", cfg.Theme.Scale)
+	}
+	if cfg.Theme.LineHeight <= 0 {
+		return nil, fmt.Errorf("theme.line_height: must be positive, got %g", cfg.Theme.LineHeight)
+	}
+	if err := cfg.Theme.Window.validate(); err != nil {
+		return nil, fmt.Errorf("theme.window: %w", err)
+	}
+
+	if err := cfg.Format.validate(); err != nil {
+		return nil, fmt.Errorf("format: %w", err)
+	}
+	if cfg.HTML.Style != "inline" && cfg.HTML.Style != "class" {
+		return nil, fmt.Errorf("html.style: must be inline or class, got %q", cfg.HTML.Style)
+	}
+	for _, session := range cfg.Sessions {
+		for _, prompt := range session.Prompts {
+			if err := prompt.Format.validate(); err != nil {
+				return nil, fmt.Errorf("session %s: format: %w", session.Name, err)
+			}
+			if prompt.Crop != nil {
+				if err := prompt.Crop.validate(); err != nil {
+					return nil, fmt.Errorf("session %s: crop: %w", session.Name, err)
+				}
+			}
+		}
+	}
+
+	for i := range cfg.Sessions {
+		animate := &cfg.Sessions[i].Animate
+		if animate.Delay <= 0 {
+			animate.Delay = 100
+		}
+		if animate.Typing <= 0 {
+			animate.Typing = 50
+		}
+	}
+
 	// Expand ~ in paths
 	cfg.Output = expandPath(cfg.Output)
+	cfg.Theme.Font = fontPath(cfg.Theme.Font, path)
+	if cfg.Theme.Window.MarginImage != "" {
+		cfg.Theme.Window.MarginImage = relativePath(cfg.Theme.Window.MarginImage, path)
+	}
+	for i, font := range cfg.Theme.FontFallbacks {
+		cfg.Theme.FontFallbacks[i] = fontPath(font, path)
+	}
 	for i := range cfg.Sessions {
 		cfg.Sessions[i].Cwd = expandPath(cfg.Sessions[i].Cwd)
 	}
@@ -84,6 +372,25 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// fontPath resolves a font given as a file path relative to the config
+// file, font family names are returned unchanged
+func fontPath(font, configPath string) string {
+	ext := strings.ToLower(filepath.Ext(font))
+	if !strings.ContainsAny(font, `/\`) && ext != ".ttf" && ext != ".otf" && ext != ".ttc" {
+		return font
+	}
+	return relativePath(font, configPath)
+}
+
+// relativePath resolves a path relative to the config file
+func relativePath(path, configPath string) string {
+	path = expandPath(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(configPath), path)
+	}
+	return path
+}
+
 func expandPath(path string) string {
 	if len(path) > 0 && path[0] == '~' {
 		home, _ := os.UserHomeDir()
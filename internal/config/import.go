@@ -0,0 +1,496 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ansiKeys are the color names used by Alacritty, Windows Terminal and
+// similar formats, in palette order
+var ansiKeys = [8]string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// importScheme reads a terminal color scheme file. Supported formats are
+// iTerm2 (.itermcolors), Alacritty (TOML or YAML), Windows Terminal (JSON)
+// and base16 (YAML).
+func importScheme(path string) (colorScheme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return colorScheme{}, err
+	}
+
+	var scheme colorScheme
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".itermcolors", ".plist":
+		scheme, err = parseITerm(data)
+	case ".toml":
+		scheme, err = parseAlacrittyTOML(data)
+	case ".json":
+		scheme, err = parseWindowsTerminal(data)
+	case ".yaml", ".yml":
+		scheme, err = parseSchemeYAML(data)
+	default:
+		return colorScheme{}, fmt.Errorf("unsupported color scheme format: %s", path)
+	}
+	if err != nil {
+		return colorScheme{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return scheme, nil
+}
+
+// parseITerm parses an iTerm2 .itermcolors property list
+func parseITerm(data []byte) (colorScheme, error) {
+	var scheme colorScheme
+
+	dec := xml.NewDecoder(strings.NewReader(string(data)))
+	root, err := decodePlist(dec)
+	if err != nil {
+		return scheme, err
+	}
+	colors, ok := root.(map[string]interface{})
+	if !ok {
+		return scheme, fmt.Errorf("expected a dictionary of colors")
+	}
+
+	for key, value := range colors {
+		components, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		hex := iTermColor(components)
+
+		var idx int
+		switch {
+		case key == "Background Color":
+			scheme.Background = hex
+		case key == "Foreground Color":
+			scheme.Foreground = hex
+		case key == "Cursor Color":
+			scheme.Cursor = hex
+		default:
+			if n, _ := fmt.Sscanf(key, "Ansi %d Color", &idx); n == 1 && idx >= 0 && idx < 16 {
+				scheme.ANSI[idx] = hex
+			}
+		}
+	}
+
+	return scheme, nil
+}
+
+// iTermColor converts iTerm2 color components (0-1 floats) to a hex color
+func iTermColor(components map[string]interface{}) string {
+	component := func(name string) uint8 {
+		v, _ := components[name+" Component"].(float64)
+		if v < 0 {
+			v = 0
+		}
+		if v > 1 {
+			v = 1
+		}
+		return uint8(v*255 + 0.5)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", component("Red"), component("Green"), component("Blue"))
+}
+
+// decodePlist decodes the first value of a property list into Go values
+// (dictionaries, arrays, strings and numbers)
+func decodePlist(dec *xml.Decoder) (interface{}, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("empty property list")
+			}
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "plist" {
+			continue
+		}
+		return decodePlistValue(dec, start)
+	}
+}
+
+func decodePlistValue(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := map[string]interface{}{}
+		var key string
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := dec.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				value, err := decodePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+
+	case "array":
+		var array []interface{}
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				value, err := decodePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+
+	case "real", "integer":
+		var s string
+		if err := dec.DecodeElement(&s, &start); err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+
+	case "true", "false":
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+
+	default:
+		var s string
+		if err := dec.DecodeElement(&s, &start); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// alacrittyColors is the color section of an Alacritty config
+type alacrittyColors struct {
+	Primary map[string]string `yaml:"primary"`
+	Cursor  map[string]string `yaml:"cursor"`
+	Normal  map[string]string `yaml:"normal"`
+	Bright  map[string]string `yaml:"bright"`
+}
+
+// scheme converts Alacritty colors to a color scheme
+func (c alacrittyColors) scheme() colorScheme {
+	scheme := colorScheme{
+		Background: alacrittyColor(c.Primary["background"]),
+		Foreground: alacrittyColor(c.Primary["foreground"]),
+		Cursor:     alacrittyColor(c.Cursor["cursor"]),
+	}
+	for i, name := range ansiKeys {
+		scheme.ANSI[i] = alacrittyColor(c.Normal[name])
+		scheme.ANSI[i+8] = alacrittyColor(c.Bright[name])
+	}
+	return scheme
+}
+
+// alacrittyColor normalizes an Alacritty color. CellForeground and
+// CellBackground, the colors of the cell under the cursor,
+// have no fixed value and count as not set.
+func alacrittyColor(s string) string {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(s)), "cell") {
+		return ""
+	}
+	return normalizeHex(s)
+}
+
+// parseAlacrittyTOML parses the colors of an Alacritty TOML config
+func parseAlacrittyTOML(data []byte) (colorScheme, error) {
+	tables := parseSimpleTOML(string(data))
+
+	colors := alacrittyColors{
+		Primary: tables["colors.primary"],
+		Cursor:  tables["colors.cursor"],
+		Normal:  tables["colors.normal"],
+		Bright:  tables["colors.bright"],
+	}
+	if colors.Primary == nil && colors.Normal == nil {
+		return colorScheme{}, fmt.Errorf("no [colors] section found")
+	}
+	return colors.scheme(), nil
+}
+
+// parseSimpleTOML parses the subset of TOML used by terminal color configs:
+// tables, dotted keys, inline tables and string values. Values are returned
+// per table, keyed by the full dotted table name.
+func parseSimpleTOML(src string) map[string]map[string]string {
+	tables := map[string]map[string]string{}
+	set := func(table, key, value string) {
+		// Dotted keys extend the table name
+		if dot := strings.LastIndex(key, "."); dot != -1 {
+			table = joinKey(table, key[:dot])
+			key = key[dot+1:]
+		}
+		if tables[table] == nil {
+			tables[table] = map[string]string{}
+		}
+		tables[table][key] = value
+	}
+
+	table := ""
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(stripTOMLComment(line))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			name := strings.Trim(line, "[] ")
+			table = unquoteTOMLKey(name)
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq == -1 {
+			// Continuation of a multi-line value we don't need
+			continue
+		}
+		key := unquoteTOMLKey(strings.TrimSpace(line[:eq]))
+		value := strings.TrimSpace(line[eq+1:])
+
+		if strings.HasPrefix(value, "{") {
+			// Inline table
+			inner := strings.TrimSuffix(strings.TrimPrefix(value, "{"), "}")
+			for _, pair := range strings.Split(inner, ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				set(joinKey(table, key), unquoteTOMLKey(strings.TrimSpace(kv[0])), unquoteTOMLValue(kv[1]))
+			}
+			continue
+		}
+
+		set(table, key, unquoteTOMLValue(value))
+	}
+
+	return tables
+}
+
+// stripTOMLComment removes a trailing comment outside of quoted strings
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteTOMLKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(p), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+func unquoteTOMLValue(value string) string {
+	return strings.Trim(strings.TrimSpace(value), `"'`)
+}
+
+func joinKey(table, key string) string {
+	if table == "" {
+		return key
+	}
+	return table + "." + key
+}
+
+// windowsTerminalScheme is a color scheme of Windows Terminal settings
+type windowsTerminalScheme struct {
+	Name         string `json:"name"`
+	Background   string `json:"background"`
+	Foreground   string `json:"foreground"`
+	CursorColor  string `json:"cursorColor"`
+	Black        string `json:"black"`
+	Red          string `json:"red"`
+	Green        string `json:"green"`
+	Yellow       string `json:"yellow"`
+	Blue         string `json:"blue"`
+	Purple       string `json:"purple"`
+	Cyan         string `json:"cyan"`
+	White        string `json:"white"`
+	BrightBlack  string `json:"brightBlack"`
+	BrightRed    string `json:"brightRed"`
+	BrightGreen  string `json:"brightGreen"`
+	BrightYellow string `json:"brightYellow"`
+	BrightBlue   string `json:"brightBlue"`
+	BrightPurple string `json:"brightPurple"`
+	BrightCyan   string `json:"brightCyan"`
+	BrightWhite  string `json:"brightWhite"`
+}
+
+// parseWindowsTerminal parses a Windows Terminal scheme, either on its own
+// or the first entry of the "schemes" list of a settings.json
+func parseWindowsTerminal(data []byte) (colorScheme, error) {
+	data = stripJSONC(data)
+	var settings struct {
+		Schemes []windowsTerminalScheme `json:"schemes"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return colorScheme{}, err
+	}
+
+	var wt windowsTerminalScheme
+	if len(settings.Schemes) > 0 {
+		wt = settings.Schemes[0]
+	} else if err := json.Unmarshal(data, &wt); err != nil {
+		return colorScheme{}, err
+	}
+	if wt.Background == "" && wt.Black == "" {
+		return colorScheme{}, fmt.Errorf("no color scheme found")
+	}
+
+	return colorScheme{
+		Background: normalizeHex(wt.Background),
+		Foreground: normalizeHex(wt.Foreground),
+		Cursor:     normalizeHex(wt.CursorColor),
+		ANSI: [16]string{
+			normalizeHex(wt.Black), normalizeHex(wt.Red), normalizeHex(wt.Green), normalizeHex(wt.Yellow),
+			normalizeHex(wt.Blue), normalizeHex(wt.Purple), normalizeHex(wt.Cyan), normalizeHex(wt.White),
+			normalizeHex(wt.BrightBlack), normalizeHex(wt.BrightRed), normalizeHex(wt.BrightGreen), normalizeHex(wt.BrightYellow),
+			normalizeHex(wt.BrightBlue), normalizeHex(wt.BrightPurple), normalizeHex(wt.BrightCyan), normalizeHex(wt.BrightWhite),
+		},
+	}, nil
+}
+
+// stripJSONC removes the comments and trailing commas settings.json files
+// may contain, which encoding/json rejects
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end == -1 {
+				return out
+			}
+			i += end + 3
+		case c == '}' || c == ']':
+			// Drop a comma before the closing bracket
+			trimmed := bytes.TrimRight(out, " \t\r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				out = append(trimmed[:len(trimmed)-1], out[len(trimmed):]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// base16Slots maps each ANSI color to its base16 slot (as in base16-shell)
+var base16Slots = [16]string{
+	"base00", "base08", "base0B", "base0A", "base0D", "base0E", "base0C", "base05",
+	"base03", "base08", "base0B", "base0A", "base0D", "base0E", "base0C", "base07",
+}
+
+// parseSchemeYAML parses a YAML color scheme, either an Alacritty config or a base16 scheme
+func parseSchemeYAML(data []byte) (colorScheme, error) {
+	var doc struct {
+		Colors  *alacrittyColors  `yaml:"colors"`
+		Palette map[string]string `yaml:"palette"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return colorScheme{}, err
+	}
+	if doc.Colors != nil {
+		return doc.Colors.scheme(), nil
+	}
+
+	// base16: either top-level baseXX keys or a "palette" map (tinted-theming)
+	base := doc.Palette
+	if base == nil {
+		if err := yaml.Unmarshal(data, &base); err != nil {
+			return colorScheme{}, fmt.Errorf("unrecognized color scheme")
+		}
+	}
+	lookup := func(slot string) string {
+		for k, v := range base {
+			if strings.EqualFold(k, slot) {
+				return normalizeHex(v)
+			}
+		}
+		return ""
+	}
+	if lookup("base00") == "" {
+		return colorScheme{}, fmt.Errorf("unrecognized color scheme")
+	}
+
+	scheme := colorScheme{
+		Background: lookup("base00"),
+		Foreground: lookup("base05"),
+		Cursor:     lookup("base05"),
+	}
+	for i, slot := range base16Slots {
+		scheme.ANSI[i] = lookup(slot)
+	}
+	return scheme, nil
+}
+
+// normalizeHex converts "0xrrggbb", "rrggbb" and "#rrggbb" colors to "#rrggbb"
+func normalizeHex(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return "#" + strings.TrimPrefix(s, "#")
+}
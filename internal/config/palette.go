@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Palette holds the resolved colors of a theme
+type Palette struct {
+	ANSI       [16]color.RGBA
+	Foreground color.RGBA
+	Background color.RGBA
+	Cursor     color.RGBA
+}
+
+// DefaultANSI is the standard 16-color palette (VS Code-like)
+var DefaultANSI = [16]color.RGBA{
+	{0, 0, 0, 255},       // 0: Black
+	{205, 49, 49, 255},   // 1: Red
+	{13, 188, 121, 255},  // 2: Green
+	{229, 229, 16, 255},  // 3: Yellow
+	{36, 114, 200, 255},  // 4: Blue
+	{188, 63, 188, 255},  // 5: Magenta
+	{17, 168, 205, 255},  // 6: Cyan
+	{229, 229, 229, 255}, // 7: White
+	{102, 102, 102, 255}, // 8: Bright Black (Gray)
+	{241, 76, 76, 255},   // 9: Bright Red
+	{35, 209, 139, 255},  // 10: Bright Green
+	{245, 245, 67, 255},  // 11: Bright Yellow
+	{59, 142, 234, 255},  // 12: Bright Blue
+	{214, 112, 214, 255}, // 13: Bright Magenta
+	{41, 184, 219, 255},  // 14: Bright Cyan
+	{255, 255, 255, 255}, // 15: Bright White
+}
+
+// ansiNames maps color names to palette indexes
+var ansiNames = map[string]int{
+	"black":         0,
+	"red":           1,
+	"green":         2,
+	"yellow":        3,
+	"blue":          4,
+	"magenta":       5,
+	"cyan":          6,
+	"white":         7,
+	"brightblack":   8,
+	"brightred":     9,
+	"brightgreen":   10,
+	"brightyellow":  11,
+	"brightblue":    12,
+	"brightmagenta": 13,
+	"brightcyan":    14,
+	"brightwhite":   15,
+}
+
+// Palette resolves the theme colors into a palette.
+// theme.colors keys are color names (black…brightWhite, case, "-" and "_"
+// insensitive), indexes 0-15 or "cursor".
+// A transparent background has an alpha of 0.
+func (t Theme) Palette() (Palette, error) {
+	p := Palette{
+		ANSI:       DefaultANSI,
+		Foreground: color.RGBA{212, 212, 212, 255},
+		Background: color.RGBA{26, 26, 26, 255},
+	}
+
+	var err error
+	if t.Foreground != "" {
+		if p.Foreground, err = ParseColor(t.Foreground); err != nil {
+			return p, fmt.Errorf("theme.foreground: %w", err)
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(t.Background), "transparent") {
+		p.Background = color.RGBA{}
+	} else if t.Background != "" {
+		if p.Background, err = ParseColor(t.Background); err != nil {
+			return p, fmt.Errorf("theme.background: %w", err)
+		}
+	}
+	p.Cursor = p.Foreground
+
+	for key, value := range t.Colors {
+		c, err := ParseColor(value)
+		if err != nil {
+			return p, fmt.Errorf("theme.colors.%s: %w", key, err)
+		}
+
+		name := canonicalColorKey(key)
+		if idx, err := strconv.Atoi(name); err == nil && idx >= 0 && idx < len(p.ANSI) {
+			p.ANSI[idx] = c
+			continue
+		}
+		switch name {
+		case "cursor":
+			p.Cursor = c
+		default:
+			return p, fmt.Errorf("theme.colors: unknown color %q", key)
+		}
+	}
+
+	if t.Cursor.Color != "" {
+		if p.Cursor, err = ParseColor(t.Cursor.Color); err != nil {
+			return p, fmt.Errorf("theme.cursor.color: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// cubeLevels are the channel values of the xterm 6x6x6 color cube
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+// Color256 returns a color from the xterm 256-color palette
+func (p Palette) Color256(idx int) color.RGBA {
+	switch {
+	case idx < 0 || idx > 255:
+		return p.Foreground
+	case idx < 16:
+		return p.ANSI[idx]
+	case idx < 232:
+		// 216 colors (6x6x6 cube)
+		idx -= 16
+		return color.RGBA{cubeLevels[idx/36], cubeLevels[idx/6%6], cubeLevels[idx%6], 255}
+	}
+	// 24 grayscale
+	gray := uint8((idx-232)*10 + 8)
+	return color.RGBA{gray, gray, gray, 255}
+}
+
+// ParseColor parses a hex color string like "#1a1a1a" or "#fff"
+func ParseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// canonicalColorKey maps a theme.colors key to its palette index ("0"-"15"),
+// or "cursor". Unknown keys are returned unchanged.
+func canonicalColorKey(key string) string {
+	if idx, err := strconv.Atoi(strings.TrimSpace(key)); err == nil {
+		if idx >= 0 && idx < 16 {
+			return strconv.Itoa(idx)
+		}
+		return key
+	}
+
+	name := strings.ToLower(key)
+	name = strings.NewReplacer("_", "", "-", "", " ", "").Replace(name)
+
+	if idx, ok := ansiNames[name]; ok {
+		return strconv.Itoa(idx)
+	}
+	if name == "cursor" {
+		return name
+	}
+	return key
+}
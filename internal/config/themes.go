@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// colorScheme describes the colors of a built-in or imported theme
+type colorScheme struct {
+	Background string
+	Foreground string
+	Cursor     string
+	ANSI       [16]string // black…white, brightBlack…brightWhite
+}
+
+// builtinThemes are the themes selectable with theme.name
+var builtinThemes = map[string]colorScheme{
+	"dark": {
+		Background: "#1a1a1a",
+		Foreground: "#d4d4d4",
+		Cursor:     "#d4d4d4",
+		ANSI: [16]string{
+			"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+			"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+		},
+	},
+	"dracula": {
+		Background: "#282a36",
+		Foreground: "#f8f8f2",
+		Cursor:     "#f8f8f2",
+		ANSI: [16]string{
+			"#21222c", "#ff5555", "#50fa7b", "#f1fa8c", "#bd93f9", "#ff79c6", "#8be9fd", "#f8f8f2",
+			"#6272a4", "#ff6e6e", "#69ff94", "#ffffa5", "#d6acff", "#ff92df", "#a4ffff", "#ffffff",
+		},
+	},
+	"solarized-dark": {
+		Background: "#002b36",
+		Foreground: "#839496",
+		Cursor:     "#93a1a1",
+		ANSI: [16]string{
+			"#073642", "#dc322f", "#859900", "#b58900", "#268bd2", "#d33682", "#2aa198", "#eee8d5",
+			"#002b36", "#cb4b16", "#586e75", "#657b83", "#839496", "#6c71c4", "#93a1a1", "#fdf6e3",
+		},
+	},
+	"solarized-light": {
+		Background: "#fdf6e3",
+		Foreground: "#657b83",
+		Cursor:     "#586e75",
+		ANSI: [16]string{
+			"#073642", "#dc322f", "#859900", "#b58900", "#268bd2", "#d33682", "#2aa198", "#eee8d5",
+			"#002b36", "#cb4b16", "#586e75", "#657b83", "#839496", "#6c71c4", "#93a1a1", "#fdf6e3",
+		},
+	},
+	"one-dark": {
+		Background: "#282c34",
+		Foreground: "#abb2bf",
+		Cursor:     "#528bff",
+		ANSI: [16]string{
+			"#282c34", "#e06c75", "#98c379", "#e5c07b", "#61afef", "#c678dd", "#56b6c2", "#abb2bf",
+			"#5c6370", "#e06c75", "#98c379", "#e5c07b", "#61afef", "#c678dd", "#56b6c2", "#ffffff",
+		},
+	},
+	"nord": {
+		Background: "#2e3440",
+		Foreground: "#d8dee9",
+		Cursor:     "#d8dee9",
+		ANSI: [16]string{
+			"#3b4252", "#bf616a", "#a3be8c", "#ebcb8b", "#81a1c1", "#b48ead", "#88c0d0", "#e5e9f0",
+			"#4c566a", "#bf616a", "#a3be8c", "#ebcb8b", "#81a1c1", "#b48ead", "#8fbcbb", "#eceff4",
+		},
+	},
+	"gruvbox": {
+		Background: "#282828",
+		Foreground: "#ebdbb2",
+		Cursor:     "#ebdbb2",
+		ANSI: [16]string{
+			"#282828", "#cc241d", "#98971a", "#d79921", "#458588", "#b16286", "#689d6a", "#a89984",
+			"#928374", "#fb4934", "#b8bb26", "#fabd2f", "#83a598", "#d3869b", "#8ec07c", "#ebdbb2",
+		},
+	},
+	"github-dark": {
+		Background: "#0d1117",
+		Foreground: "#c9d1d9",
+		Cursor:     "#58a6ff",
+		ANSI: [16]string{
+			"#484f58", "#ff7b72", "#3fb950", "#d29922", "#58a6ff", "#bc8cff", "#39c5cf", "#b1bac4",
+			"#6e7681", "#ffa198", "#56d364", "#e3b341", "#79c0ff", "#d2a8ff", "#56d4dd", "#f0f6fc",
+		},
+	},
+	"github-light": {
+		Background: "#ffffff",
+		Foreground: "#24292f",
+		Cursor:     "#0969da",
+		ANSI: [16]string{
+			"#24292f", "#cf222e", "#116329", "#4d2d00", "#0969da", "#8250df", "#1b7c83", "#6e7781",
+			"#57606a", "#a40e26", "#1a7f37", "#633c01", "#218bff", "#a475f9", "#3192aa", "#8c959f",
+		},
+	},
+	"monokai": {
+		Background: "#272822",
+		Foreground: "#f8f8f2",
+		Cursor:     "#f8f8f0",
+		ANSI: [16]string{
+			"#272822", "#f92672", "#a6e22e", "#f4bf75", "#66d9ef", "#ae81ff", "#a1efe4", "#f8f8f2",
+			"#75715e", "#f92672", "#a6e22e", "#f4bf75", "#66d9ef", "#ae81ff", "#a1efe4", "#f9f8f5",
+		},
+	},
+}
+
+// themeAliases maps alternative theme names to built-in themes
+var themeAliases = map[string]string{
+	"default":      "dark",
+	"gruvbox-dark": "gruvbox",
+	"onedark":      "one-dark",
+}
+
+// ThemeNames returns the names of the built-in themes
+func ThemeNames() []string {
+	var names []string
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupTheme finds a built-in theme by name
+func lookupTheme(name string) (colorScheme, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.NewReplacer(" ", "-", "_", "-").Replace(name)
+	if alias, ok := themeAliases[name]; ok {
+		name = alias
+	}
+	theme, ok := builtinThemes[name]
+	return theme, ok
+}
+
+// applyBuiltin fills the colors the user did not set from the named built-in theme
+func (t *Theme) applyBuiltin() error {
+	builtin, ok := lookupTheme(t.Name)
+	if !ok {
+		return fmt.Errorf("theme.name: unknown theme %q (available: %s)", t.Name, strings.Join(ThemeNames(), ", "))
+	}
+	t.applyScheme(builtin)
+	return nil
+}
+
+// applyScheme fills the colors that are still unset from a color scheme.
+// The scheme's cursor color is only used if the foreground isn't set either,
+// otherwise the cursor keeps following the foreground.
+func (t *Theme) applyScheme(scheme colorScheme) {
+	foregroundSet := t.Foreground != ""
+	if t.Background == "" {
+		t.Background = scheme.Background
+	}
+	if t.Foreground == "" {
+		t.Foreground = scheme.Foreground
+	}
+
+	colors := map[string]string{}
+	for key, value := range t.Colors {
+		colors[canonicalColorKey(key)] = value
+	}
+	setMissing := func(key, value string) {
+		if _, ok := colors[key]; !ok && value != "" {
+			colors[key] = value
+		}
+	}
+	if !foregroundSet && t.Cursor.Color == "" {
+		setMissing("cursor", scheme.Cursor)
+	}
+	for i, c := range scheme.ANSI {
+		setMissing(strconv.Itoa(i), c)
+	}
+	t.Colors = colors
+}
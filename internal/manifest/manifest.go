@@ -1,7 +1,9 @@
 package manifest
 
 import (
+	"bytes"
 	"encoding/json"
+	"math"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,20 +14,21 @@ import (
 
 // Manifest represents the output manifest
 type Manifest struct {
-	Tool        string           `json:"tool"`
-	Version     string           `json:"version"`
-	Target      string           `json:"target"`
-	GeneratedAt string           `json:"generated_at"`
-	Terminal    TerminalInfo     `json:"terminal"`
+	Tool        string            `json:"tool"`
+	Version     string            `json:"version"`
+	Target      string            `json:"target"`
+	GeneratedAt string            `json:"generated_at"`
+	Terminal    TerminalInfo      `json:"terminal"`
 	Sessions    []SessionManifest `json:"sessions"`
-	Summary     Summary          `json:"summary"`
+	Summary     Summary           `json:"summary"`
 }
 
 // TerminalInfo describes terminal settings
 type TerminalInfo struct {
-	Width  int    `json:"width"`
-	Height int    `json:"height"`
-	Theme  string `json:"theme"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Theme  string  `json:"theme"`
+	Scale  float64 `json:"scale"`
 }
 
 // SessionManifest describes a session in the manifest
@@ -34,13 +37,30 @@ type SessionManifest struct {
 	Description string               `json:"description"`
 	Cwd         string               `json:"cwd"`
 	Screenshots []ScreenshotManifest `json:"screenshots"`
+	Animation   string               `json:"animation,omitempty"`
+	Cast        string               `json:"cast,omitempty"`
 }
 
 // ScreenshotManifest describes a screenshot
 type ScreenshotManifest struct {
-	Filename string `json:"filename"`
-	Prompt   string `json:"prompt,omitempty"`
-	WaitMs   int    `json:"wait_ms,omitempty"`
+	Filename       string        `json:"filename"`
+	Files          []string      `json:"files,omitempty"`
+	Prompt         string        `json:"prompt,omitempty"`
+	WaitMs         int           `json:"wait_ms,omitempty"`
+	Width          int           `json:"width"` // logical size, physical size divided by the scale
+	Height         int           `json:"height"`
+	PhysicalWidth  int           `json:"physical_width"`
+	PhysicalHeight int           `json:"physical_height"`
+	Links          []string      `json:"links,omitempty"` // OSC 8 hyperlink targets
+	Crop           *CropManifest `json:"crop,omitempty"`
+}
+
+// CropManifest is the part of the screen a cropped screenshot shows, the
+// first and last row and column counted from 1. With the scrollback
+// captured, rows count from its first line.
+type CropManifest struct {
+	Rows [2]int `json:"rows"`
+	Cols [2]int `json:"cols"`
 }
 
 // Summary provides aggregate stats
@@ -62,6 +82,7 @@ func Generate(cfg *config.Config, results []runner.SessionResult, outputDir stri
 			Width:  cfg.Terminal.Width,
 			Height: cfg.Terminal.Height,
 			Theme:  cfg.Theme.Name,
+			Scale:  cfg.Theme.Scale,
 		},
 	}
 
@@ -74,13 +95,26 @@ func Generate(cfg *config.Config, results []runner.SessionResult, outputDir stri
 			Name:        result.Name,
 			Description: result.Description,
 			Cwd:         result.Cwd,
+			Animation:   result.Animation,
+			Cast:        result.Cast,
 		}
 
 		for _, ss := range result.Screenshots {
+			var crop *CropManifest
+			if ss.Crop != nil {
+				crop = &CropManifest{Rows: ss.Crop.Rows, Cols: ss.Crop.Cols}
+			}
 			session.Screenshots = append(session.Screenshots, ScreenshotManifest{
-				Filename: ss.Filename,
-				Prompt:   ss.Prompt,
-				WaitMs:   ss.WaitMs,
+				Filename:       ss.Filename,
+				Files:          ss.Files,
+				Prompt:         ss.Prompt,
+				WaitMs:         ss.WaitMs,
+				Width:          logicalSize(ss.Width, cfg.Theme.Scale),
+				Height:         logicalSize(ss.Height, cfg.Theme.Scale),
+				PhysicalWidth:  ss.Width,
+				PhysicalHeight: ss.Height,
+				Links:          ss.Links,
+				Crop:           crop,
 			})
 			totalScreenshots++
 		}
@@ -101,12 +135,23 @@ func Generate(cfg *config.Config, results []runner.SessionResult, outputDir stri
 		Failed:           failedSessions,
 	}
 
-	// Write manifest
+	// Write manifest, leaving the & in link targets unescaped
 	outputPath := filepath.Join(outputDir, "manifest.json")
-	data, err := json.MarshalIndent(manifest, "", "  ")
-	if err != nil {
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
 		return err
 	}
 
-	return os.WriteFile(outputPath, data, 0644)
+	return os.WriteFile(outputPath, data.Bytes(), 0644)
+}
+
+// logicalSize converts a size in physical pixels to logical pixels
+func logicalSize(physical int, scale float64) int {
+	if scale <= 0 {
+		return physical
+	}
+	return int(math.Round(float64(physical) / scale))
 }
@@ -0,0 +1,465 @@
+package renderer
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// Box drawing, block element and braille characters are drawn as shapes
+// sized to the cell instead of font glyphs, so neighbouring cells join up
+// without seams at any scale.
+
+// Line weights of box drawing characters
+const (
+	lineNone   = '0'
+	lineLight  = '1'
+	lineHeavy  = '2'
+	lineDouble = '3'
+)
+
+// boxLines are the line weights of box drawing characters going up, right,
+// down and left from the center of the cell
+var boxLines = map[rune]string{
+	'─': "0101", '━': "0202", '│': "1010", '┃': "2020",
+	'┌': "0110", '┍': "0210", '┎': "0120", '┏': "0220",
+	'┐': "0011", '┑': "0012", '┒': "0021", '┓': "0022",
+	'└': "1100", '┕': "1200", '┖': "2100", '┗': "2200",
+	'┘': "1001", '┙': "1002", '┚': "2001", '┛': "2002",
+	'├': "1110", '┝': "1210", '┞': "2110", '┟': "1120",
+	'┠': "2120", '┡': "2210", '┢': "1220", '┣': "2220",
+	'┤': "1011", '┥': "1012", '┦': "2011", '┧': "1021",
+	'┨': "2021", '┩': "2012", '┪': "1022", '┫': "2022",
+	'┬': "0111", '┭': "0112", '┮': "0211", '┯': "0212",
+	'┰': "0121", '┱': "0122", '┲': "0221", '┳': "0222",
+	'┴': "1101", '┵': "1102", '┶': "1201", '┷': "1202",
+	'┸': "2101", '┹': "2102", '┺': "2201", '┻': "2202",
+	'┼': "1111", '┽': "1112", '┾': "1211", '┿': "1212",
+	'╀': "2111", '╁': "1121", '╂': "2121", '╃': "2112",
+	'╄': "2211", '╅': "1122", '╆': "1221", '╇': "2212",
+	'╈': "1222", '╉': "2122", '╊': "2221", '╋': "2222",
+	'═': "0303", '║': "3030", '╒': "0310", '╓': "0130",
+	'╔': "0330", '╕': "0013", '╖': "0031", '╗': "0033",
+	'╘': "1300", '╙': "3100", '╚': "3300", '╛': "1003",
+	'╜': "3001", '╝': "3003", '╞': "1310", '╟': "3130",
+	'╠': "3330", '╡': "1013", '╢': "3031", '╣': "3033",
+	'╤': "0313", '╥': "0131", '╦': "0333", '╧': "1303",
+	'╨': "3101", '╩': "3303", '╪': "1313", '╫': "3131",
+	'╬': "3333", '╴': "0001", '╵': "1000", '╶': "0100",
+	'╷': "0010", '╸': "0002", '╹': "2000", '╺': "0200",
+	'╻': "0020", '╼': "0201", '╽': "1020", '╾': "0102",
+	'╿': "2010",
+}
+
+// boxDashes are the dashed box drawing lines
+var boxDashes = map[rune]struct {
+	count    int
+	weight   byte
+	vertical bool
+}{
+	'┄': {3, lineLight, false}, '┅': {3, lineHeavy, false},
+	'┆': {3, lineLight, true}, '┇': {3, lineHeavy, true},
+	'┈': {4, lineLight, false}, '┉': {4, lineHeavy, false},
+	'┊': {4, lineLight, true}, '┋': {4, lineHeavy, true},
+	'╌': {2, lineLight, false}, '╍': {2, lineHeavy, false},
+	'╎': {2, lineLight, true}, '╏': {2, lineHeavy, true},
+}
+
+// boxQuadrants are the quadrants filled by quadrant block elements:
+// 1 upper left, 2 upper right, 4 lower left, 8 lower right
+var boxQuadrants = map[rune]int{
+	'▖': 4, '▗': 8, '▘': 1, '▙': 13, '▚': 9,
+	'▛': 7, '▜': 11, '▝': 2, '▞': 6, '▟': 14,
+}
+
+// isBoxChar reports whether a character is drawn as shapes
+func isBoxChar(ch rune) bool {
+	return ch >= 0x2500 && ch <= 0x259F || ch >= 0x2800 && ch <= 0x28FF
+}
+
+// boxPen draws the shapes of box characters
+type boxPen interface {
+	fillRect(x0, y0, x1, y1, alpha float64)
+	strokeLine(x0, y0, x1, y1, width float64)
+	strokeArc(cx, cy, radius, angle1, angle2, width float64)
+	fillCircle(cx, cy, radius float64)
+}
+
+// boxCell is a cell snapped to whole pixels, with the line widths used in it
+type boxCell struct {
+	x0, y0, x1, y1 float64
+	light, heavy   float64
+	gap            float64 // distance of the rails of a double line from the center
+}
+
+// newBoxCell returns the cell at x, top snapped to whole pixels
+func (r *Renderer) newBoxCell(x, top float64) boxCell {
+	light := math.Max(1, math.Round(math.Max(r.fontSize/14, r.scale)))
+	c := boxCell{
+		x0:    math.Round(x),
+		y0:    math.Round(top),
+		x1:    math.Round(x + r.charWidth),
+		y1:    math.Round(top + r.charHeight),
+		light: light,
+		heavy: 2 * light,
+	}
+	c.gap = math.Max(light, math.Round(math.Min(c.x1-c.x0, c.y1-c.y0)/5))
+	return c
+}
+
+// thickness returns the width of a line of the given weight
+func (c boxCell) thickness(weight byte) float64 {
+	if weight == lineHeavy {
+		return c.heavy
+	}
+	return c.light
+}
+
+// centerX returns the x center of a vertical line, placed so its edges fall on whole pixels
+func (c boxCell) centerX(width float64) float64 {
+	return math.Round((c.x0+c.x1)/2-width/2) + width/2
+}
+
+// centerY returns the y center of a horizontal line, placed so its edges fall on whole pixels
+func (c boxCell) centerY(width float64) float64 {
+	return math.Round((c.y0+c.y1)/2-width/2) + width/2
+}
+
+// lineEnd returns where a line running from the edge toward the center of
+// the cell stops, given the weights of the two lines at right angles to it.
+// rail is -1 or 1 for the rails of a double line, 0 for other lines. sign
+// is -1 for lines coming from the right or bottom, 1 for the left or top.
+func (c boxCell) lineEnd(weight, across1, across2 byte, rail int, center func(float64) float64, sign float64) float64 {
+	mid := center(c.light)
+	meet := mid - c.light/2 // on a whole pixel, where lines from opposite edges meet
+	if weight != lineDouble {
+		switch {
+		case across1 == lineNone && across2 == lineNone:
+			return meet
+		case across1 == lineDouble && across2 == lineDouble:
+			// Stop at the nearer rail of a double line crossing the cell
+			return mid - sign*(c.gap+c.light/2)
+		case across1 == lineDouble || across2 == lineDouble:
+			// Reach the far rail of a double line ending here
+			return mid + sign*(c.gap+c.light/2)
+		}
+		// Cover the widest line crossing, so corners are square
+		width := 0.0
+		for _, across := range []byte{across1, across2} {
+			if across != lineNone {
+				width = math.Max(width, c.thickness(across))
+			}
+		}
+		return center(width) + sign*width/2
+	}
+
+	near, far := across1, across2
+	if rail > 0 {
+		near, far = across2, across1
+	}
+	switch {
+	case near == lineDouble:
+		return mid + sign*(c.light/2-c.gap)
+	case near != lineNone:
+		return center(c.thickness(near)) + sign*c.thickness(near)/2
+	case far == lineDouble:
+		return mid + sign*(c.gap+c.light/2)
+	case far != lineNone:
+		return center(c.thickness(far)) + sign*c.thickness(far)/2
+	}
+	return meet
+}
+
+// drawBoxChar draws a box drawing, block element or braille character in
+// the cell at x, top. It returns false for other characters.
+func (r *Renderer) drawBoxChar(pen boxPen, ch rune, x, top float64) bool {
+	c := r.newBoxCell(x, top)
+	w, h := c.x1-c.x0, c.y1-c.y0
+
+	if lines, ok := boxLines[ch]; ok {
+		c.drawLines(pen, lines[0], lines[1], lines[2], lines[3])
+		return true
+	}
+	if dash, ok := boxDashes[ch]; ok {
+		c.drawDashes(pen, dash.count, dash.weight, dash.vertical)
+		return true
+	}
+	if quadrants, ok := boxQuadrants[ch]; ok {
+		mx, my := c.x0+math.Round(w/2), c.y0+math.Round(h/2)
+		if quadrants&1 != 0 {
+			pen.fillRect(c.x0, c.y0, mx, my, 1)
+		}
+		if quadrants&2 != 0 {
+			pen.fillRect(mx, c.y0, c.x1, my, 1)
+		}
+		if quadrants&4 != 0 {
+			pen.fillRect(c.x0, my, mx, c.y1, 1)
+		}
+		if quadrants&8 != 0 {
+			pen.fillRect(mx, my, c.x1, c.y1, 1)
+		}
+		return true
+	}
+
+	switch {
+	case ch >= '╭' && ch <= '╰':
+		c.drawArc(pen, ch)
+	case ch == '╱' || ch == '╳':
+		pen.strokeLine(c.x1, c.y0, c.x0, c.y1, c.light)
+		if ch == '╳' {
+			pen.strokeLine(c.x0, c.y0, c.x1, c.y1, c.light)
+		}
+	case ch == '╲':
+		pen.strokeLine(c.x0, c.y0, c.x1, c.y1, c.light)
+	case ch == '▀':
+		pen.fillRect(c.x0, c.y0, c.x1, c.y0+math.Round(h/2), 1)
+	case ch >= '▁' && ch <= '█':
+		eighths := float64(ch - '▀')
+		pen.fillRect(c.x0, c.y1-math.Round(h*eighths/8), c.x1, c.y1, 1)
+	case ch >= '▉' && ch <= '▏':
+		eighths := float64('█' + 8 - ch)
+		pen.fillRect(c.x0, c.y0, c.x0+math.Round(w*eighths/8), c.y1, 1)
+	case ch == '▐':
+		pen.fillRect(c.x0+math.Round(w/2), c.y0, c.x1, c.y1, 1)
+	case ch >= '░' && ch <= '▓':
+		pen.fillRect(c.x0, c.y0, c.x1, c.y1, float64(ch-'░'+1)/4)
+	case ch == '▔':
+		pen.fillRect(c.x0, c.y0, c.x1, c.y0+math.Round(h/8), 1)
+	case ch == '▕':
+		pen.fillRect(c.x1-math.Round(w/8), c.y0, c.x1, c.y1, 1)
+	case ch >= 0x2800 && ch <= 0x28FF:
+		c.drawBraille(pen, int(ch-0x2800))
+	default:
+		return false
+	}
+	return true
+}
+
+// drawLines draws solid and double lines from the center to the edges
+func (c boxCell) drawLines(pen boxPen, up, right, down, left byte) {
+	// Horizontal lines
+	for _, seg := range []struct {
+		weight byte
+		sign   float64
+	}{{right, -1}, {left, 1}} {
+		if seg.weight == lineNone {
+			continue
+		}
+		rails := []int{0}
+		if seg.weight == lineDouble {
+			rails = []int{-1, 1}
+		}
+		for _, rail := range rails {
+			width := c.thickness(seg.weight)
+			y := c.centerY(width)
+			if rail != 0 {
+				y = c.centerY(c.light) + float64(rail)*c.gap
+			}
+			end := c.lineEnd(seg.weight, up, down, rail, c.centerX, seg.sign)
+			if seg.sign < 0 {
+				pen.fillRect(end, y-width/2, c.x1, y+width/2, 1)
+			} else {
+				pen.fillRect(c.x0, y-width/2, end, y+width/2, 1)
+			}
+		}
+	}
+
+	// Vertical lines
+	for _, seg := range []struct {
+		weight byte
+		sign   float64
+	}{{down, -1}, {up, 1}} {
+		if seg.weight == lineNone {
+			continue
+		}
+		rails := []int{0}
+		if seg.weight == lineDouble {
+			rails = []int{-1, 1}
+		}
+		for _, rail := range rails {
+			width := c.thickness(seg.weight)
+			x := c.centerX(width)
+			if rail != 0 {
+				x = c.centerX(c.light) + float64(rail)*c.gap
+			}
+			end := c.lineEnd(seg.weight, left, right, rail, c.centerY, seg.sign)
+			if seg.sign < 0 {
+				pen.fillRect(x-width/2, end, x+width/2, c.y1, 1)
+			} else {
+				pen.fillRect(x-width/2, c.y0, x+width/2, end, 1)
+			}
+		}
+	}
+}
+
+// drawDashes draws a dashed line across the cell
+func (c boxCell) drawDashes(pen boxPen, count int, weight byte, vertical bool) {
+	width := c.thickness(weight)
+	if vertical {
+		x := c.centerX(width)
+		step := (c.y1 - c.y0) / float64(count)
+		gap := math.Max(1, math.Round(step/4))
+		for i := 0; i < count; i++ {
+			y := c.y0 + math.Round(float64(i)*step)
+			pen.fillRect(x-width/2, y+math.Floor(gap/2), x+width/2, c.y0+math.Round(float64(i+1)*step)-math.Ceil(gap/2), 1)
+		}
+		return
+	}
+	y := c.centerY(width)
+	step := (c.x1 - c.x0) / float64(count)
+	gap := math.Max(1, math.Round(step/4))
+	for i := 0; i < count; i++ {
+		x := c.x0 + math.Round(float64(i)*step)
+		pen.fillRect(x+math.Floor(gap/2), y-width/2, c.x0+math.Round(float64(i+1)*step)-math.Ceil(gap/2), y+width/2, 1)
+	}
+}
+
+// drawArc draws a rounded corner: ╭ ╮ ╯ ╰
+func (c boxCell) drawArc(pen boxPen, ch rune) {
+	dx, dy := 1.0, 1.0 // direction of the horizontal and vertical line
+	switch ch {
+	case '╮':
+		dx = -1
+	case '╯':
+		dx, dy = -1, -1
+	case '╰':
+		dy = -1
+	}
+
+	x, y := c.centerX(c.light), c.centerY(c.light)
+	radius := math.Min(c.x1-c.x0, c.y1-c.y0) / 2
+	cx, cy := x+dx*radius, y+dy*radius
+
+	// Straight parts from the arc to the edges
+	if dx > 0 {
+		pen.fillRect(cx, y-c.light/2, c.x1, y+c.light/2, 1)
+	} else {
+		pen.fillRect(c.x0, y-c.light/2, cx, y+c.light/2, 1)
+	}
+	if dy > 0 {
+		pen.fillRect(x-c.light/2, cy, x+c.light/2, c.y1, 1)
+	} else {
+		pen.fillRect(x-c.light/2, c.y0, x+c.light/2, cy, 1)
+	}
+
+	// The arc from the vertical line to the horizontal one
+	angle1 := math.Pi
+	if dx < 0 {
+		angle1 = 0
+	}
+	angle2 := 3 * math.Pi / 2
+	if dy < 0 {
+		angle2 = math.Pi / 2
+	}
+	if angle2-angle1 > math.Pi {
+		angle2 -= 2 * math.Pi
+	}
+	pen.strokeArc(cx, cy, radius, angle1, angle2, c.light)
+}
+
+// drawBraille draws the dots of a braille pattern
+func (c boxCell) drawBraille(pen boxPen, pattern int) {
+	// Bits of the dots by column and row
+	dots := [4][2]int{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+	w, h := c.x1-c.x0, c.y1-c.y0
+	radius := math.Min(w/2, h/4) * 0.32
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 2; col++ {
+			if pattern&dots[row][col] == 0 {
+				continue
+			}
+			pen.fillCircle(c.x0+w*float64(2*col+1)/4, c.y0+h*float64(2*row+1)/8, radius)
+		}
+	}
+}
+
+// ggPen draws box characters on a gg context
+type ggPen struct {
+	dc    *gg.Context
+	color color.NRGBA
+}
+
+// setAlpha sets the pen color with its alpha scaled
+func (p ggPen) setAlpha(alpha float64) {
+	c := p.color
+	c.A = uint8(math.Round(float64(c.A) * alpha))
+	p.dc.SetColor(c)
+}
+
+func (p ggPen) fillRect(x0, y0, x1, y1, alpha float64) {
+	p.setAlpha(alpha)
+	p.dc.DrawRectangle(x0, y0, x1-x0, y1-y0)
+	p.dc.Fill()
+}
+
+func (p ggPen) strokeLine(x0, y0, x1, y1, width float64) {
+	p.setAlpha(1)
+	p.dc.SetLineWidth(width)
+	p.dc.SetLineCapButt()
+	p.dc.DrawLine(x0, y0, x1, y1)
+	p.dc.Stroke()
+}
+
+func (p ggPen) strokeArc(cx, cy, radius, angle1, angle2, width float64) {
+	p.setAlpha(1)
+	p.dc.SetLineWidth(width)
+	p.dc.SetLineCapButt()
+	p.dc.NewSubPath()
+	p.dc.DrawArc(cx, cy, radius, angle1, angle2)
+	p.dc.Stroke()
+}
+
+func (p ggPen) fillCircle(cx, cy, radius float64) {
+	p.setAlpha(1)
+	p.dc.DrawCircle(cx, cy, radius)
+	p.dc.Fill()
+}
+
+// svgPen writes box characters as SVG elements
+type svgPen struct {
+	b     *strings.Builder
+	color color.RGBA
+	dim   bool
+}
+
+// paint returns the fill or stroke attributes of a shape
+func (p svgPen) paint(attr string, alpha float64) string {
+	s := fmt.Sprintf(`%s="%s"`, attr, hexColor(p.color))
+	if p.dim {
+		alpha *= 0.5
+	}
+	if alpha < 1 {
+		s += fmt.Sprintf(` %s-opacity="%s"`, attr, num(alpha))
+	}
+	return s
+}
+
+func (p svgPen) fillRect(x0, y0, x1, y1, alpha float64) {
+	fmt.Fprintf(p.b, `<rect x="%s" y="%s" width="%s" height="%s" %s/>`+"\n",
+		num(x0), num(y0), num(x1-x0), num(y1-y0), p.paint("fill", alpha))
+}
+
+func (p svgPen) strokeLine(x0, y0, x1, y1, width float64) {
+	fmt.Fprintf(p.b, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke-width="%s" %s/>`+"\n",
+		num(x0), num(y0), num(x1), num(y1), num(width), p.paint("stroke", 1))
+}
+
+func (p svgPen) strokeArc(cx, cy, radius, angle1, angle2, width float64) {
+	sweep := 0
+	if angle2 > angle1 {
+		sweep = 1
+	}
+	fmt.Fprintf(p.b, `<path d="M%s %s A%s %s 0 0 %d %s %s" fill="none" stroke-width="%s" %s/>`+"\n",
+		num(cx+radius*math.Cos(angle1)), num(cy+radius*math.Sin(angle1)),
+		num(radius), num(radius), sweep,
+		num(cx+radius*math.Cos(angle2)), num(cy+radius*math.Sin(angle2)),
+		num(width), p.paint("stroke", 1))
+}
+
+func (p svgPen) fillCircle(cx, cy, radius float64) {
+	fmt.Fprintf(p.b, `<circle cx="%s" cy="%s" r="%s" %s/>`+"\n",
+		num(cx), num(cy), num(radius), p.paint("fill", 1))
+}
@@ -0,0 +1,38 @@
+package renderer
+
+import (
+	"os"
+
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+)
+
+// The Go Mono family is bundled so rendering works without system fonts.
+// Its files come from golang.org/x/image/font/gofont, keyed by name.
+var embeddedFontFiles = map[string][]byte{
+	"Go-Mono.ttf":             gomono.TTF,
+	"Go-Mono-Bold.ttf":        gomonobold.TTF,
+	"Go-Mono-Italic.ttf":      gomonoitalic.TTF,
+	"Go-Mono-Bold-Italic.ttf": gomonobolditalic.TTF,
+}
+
+// embeddedFamily is the family name of the bundled font
+const embeddedFamily = "Go Mono"
+
+// embeddedFonts are the faces of the bundled font
+var embeddedFonts = []fontInfo{
+	{fontRef{path: "Go-Mono.ttf", embedded: true}, embeddedFamily, "Regular"},
+	{fontRef{path: "Go-Mono-Bold.ttf", embedded: true}, embeddedFamily, "Bold"},
+	{fontRef{path: "Go-Mono-Italic.ttf", embedded: true}, embeddedFamily, "Italic"},
+	{fontRef{path: "Go-Mono-Bold-Italic.ttf", embedded: true}, embeddedFamily, "Bold Italic"},
+}
+
+// readFontData reads the file a font reference points to
+func readFontData(ref fontRef) ([]byte, error) {
+	if ref.embedded {
+		return embeddedFontFiles[ref.path], nil
+	}
+	return os.ReadFile(ref.path)
+}
@@ -0,0 +1,559 @@
+package renderer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// colorFont is a font with color glyphs: PNG bitmaps (CBDT/CBLC or sbix,
+// e.g. Noto Color Emoji and Apple Color Emoji) or layers of colored
+// outlines (COLR/CPAL version 0, e.g. Segoe UI Emoji and Twemoji)
+type colorFont struct {
+	path       string
+	font       *sfnt.Font
+	cblc, cbdt []byte
+	sbix       []byte
+	colr, cpal []byte
+
+	// Bitmaps scaled to the cell size, by glyph and width in cells
+	scaled map[colorGlyphKey]image.Image
+}
+
+// colorGlyphKey identifies a scaled color glyph
+type colorGlyphKey struct {
+	glyph sfnt.GlyphIndex
+	wide  bool
+}
+
+// wantsColor reports whether a character is shown as a color emoji: wide
+// emoji and characters asking for emoji presentation with U+FE0F
+func wantsColor(cell ScreenCell) bool {
+	if strings.ContainsRune(cell.Combining, '\uFE0E') {
+		return false // text presentation
+	}
+	return cell.Wide || strings.ContainsRune(cell.Combining, '\uFE0F')
+}
+
+// colorFontFor returns the first color font with a glyph for the rune: the
+// configured fallbacks first, then the system fonts
+func (r *Renderer) colorFontFor(ch rune) *colorFont {
+	if cf, ok := r.colorCache[ch]; ok {
+		return cf
+	}
+
+	var found *colorFont
+	for _, cf := range r.colorFonts {
+		if cf.glyphIndex(ch) != 0 {
+			found = cf
+			break
+		}
+	}
+	for found == nil {
+		if len(r.colorQueue) == 0 {
+			if r.colorSearched {
+				break
+			}
+			r.colorSearched = true
+			r.colorQueue = systemFontFiles()
+			continue
+		}
+		path := r.colorQueue[0]
+		r.colorQueue = r.colorQueue[1:]
+		if !hasColorTables(path) {
+			continue
+		}
+		for _, cf := range loadColorFonts(path) {
+			r.colorFonts = append(r.colorFonts, cf)
+			if found == nil && cf.glyphIndex(ch) != 0 {
+				found = cf
+			}
+		}
+	}
+
+	r.colorCache[ch] = found
+	return found
+}
+
+// hasColorTables reports whether a font file has color glyphs, reading only
+// its table directories
+func hasColorTables(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return false
+	}
+	offsets := []int64{0}
+	if string(header[:4]) == "ttcf" {
+		numFonts := int(binary.BigEndian.Uint32(header[8:]))
+		if numFonts > 256 {
+			return false
+		}
+		buf := make([]byte, 4*numFonts)
+		if _, err := f.ReadAt(buf, 12); err != nil {
+			return false
+		}
+		offsets = offsets[:0]
+		for i := 0; i < numFonts; i++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(buf[4*i:])))
+		}
+	}
+
+	for _, offset := range offsets {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return false
+		}
+		numTables := int(binary.BigEndian.Uint16(header[4:]))
+		dir := make([]byte, 16*numTables)
+		if _, err := f.ReadAt(dir, offset+12); err != nil {
+			return false
+		}
+		for i := 0; i < numTables; i++ {
+			tag := string(dir[16*i : 16*i+4])
+			if tag == "CBDT" || tag == "sbix" || tag == "COLR" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadColorFonts loads the fonts with color glyphs of a font file
+func loadColorFonts(path string) []*colorFont {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	collection, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return nil
+	}
+
+	offsets := []int{0}
+	if len(data) >= 12 && string(data[:4]) == "ttcf" {
+		offsets = offsets[:0]
+		for i := 0; i < collection.NumFonts(); i++ {
+			offsets = append(offsets, sfntUint32(data, 12+4*i))
+		}
+	}
+
+	var fonts []*colorFont
+	for i, offset := range offsets {
+		f, err := collection.Font(i)
+		if err != nil {
+			continue
+		}
+		tables, err := readSfntTablesAt(data, offset)
+		if err != nil {
+			continue
+		}
+		cf := &colorFont{
+			path:   path,
+			font:   f,
+			cbdt:   tables["CBDT"],
+			cblc:   tables["CBLC"],
+			sbix:   tables["sbix"],
+			colr:   tables["COLR"],
+			cpal:   tables["CPAL"],
+			scaled: map[colorGlyphKey]image.Image{},
+		}
+		// COLR version 1 paint graphs are not supported
+		if sfntUint16(cf.colr, 0) != 0 {
+			cf.colr = nil
+		}
+		if cf.cbdt != nil && cf.cblc != nil || cf.sbix != nil || cf.colr != nil && cf.cpal != nil {
+			fonts = append(fonts, cf)
+		}
+	}
+	return fonts
+}
+
+// glyphIndex returns the glyph of a rune if the font has it in color
+func (cf *colorFont) glyphIndex(ch rune) sfnt.GlyphIndex {
+	var buf sfnt.Buffer
+	glyph, err := cf.font.GlyphIndex(&buf, ch)
+	if err != nil || glyph == 0 {
+		return 0
+	}
+	if cf.bitmapData(glyph, 0) == nil && len(cf.layers(glyph)) == 0 {
+		return 0
+	}
+	return glyph
+}
+
+// drawColorGlyph draws a color glyph scaled into the cells of a character,
+// with top the top edge of the cell and y the baseline. Layers without a
+// color of their own are drawn in fg.
+func (r *Renderer) drawColorGlyph(dc *gg.Context, cf *colorFont, cell ScreenCell, fg color.Color, x, top, y float64) bool {
+	glyph := cf.glyphIndex(cell.Char)
+	if glyph == 0 {
+		return false
+	}
+	span := r.cellSpan(cell)
+
+	if img := cf.scaledBitmap(glyph, cell.Wide, span, r.charHeight); img != nil {
+		b := img.Bounds()
+		dc.DrawImage(img, int(math.Round(x+(span-float64(b.Dx()))/2)), int(math.Round(top+(r.charHeight-float64(b.Dy()))/2)))
+		return true
+	}
+
+	layers := cf.layers(glyph)
+	if len(layers) == 0 {
+		return false
+	}
+	var buf sfnt.Buffer
+	ppem := fixed.Int26_6(math.Round(r.fontSize * 64))
+	width := span
+	if advance, err := cf.font.GlyphAdvance(&buf, glyph, ppem, font.HintingNone); err == nil {
+		width = float64(advance) / 64
+	}
+
+	dc.Push()
+	defer dc.Pop()
+	// Center the glyph in its cells, narrowing it if it doesn't fit
+	if width > span {
+		dc.ScaleAbout(span/width, 1, x, y)
+	} else {
+		x += (span - width) / 2
+	}
+	for _, layer := range layers {
+		segments, err := cf.font.LoadGlyph(&buf, layer.glyph, ppem, nil)
+		if err != nil {
+			continue
+		}
+		for _, seg := range segments {
+			p := func(i int) (float64, float64) {
+				return x + float64(seg.Args[i].X)/64, y + float64(seg.Args[i].Y)/64
+			}
+			switch seg.Op {
+			case sfnt.SegmentOpMoveTo:
+				dc.MoveTo(p(0))
+			case sfnt.SegmentOpLineTo:
+				dc.LineTo(p(0))
+			case sfnt.SegmentOpQuadTo:
+				x1, y1 := p(0)
+				x2, y2 := p(1)
+				dc.QuadraticTo(x1, y1, x2, y2)
+			case sfnt.SegmentOpCubeTo:
+				x1, y1 := p(0)
+				x2, y2 := p(1)
+				x3, y3 := p(2)
+				dc.CubicTo(x1, y1, x2, y2, x3, y3)
+			}
+		}
+		if layer.foreground {
+			dc.SetColor(fg)
+		} else {
+			dc.SetColor(layer.color)
+		}
+		dc.Fill()
+	}
+	return true
+}
+
+// scaledBitmap returns the bitmap of a glyph scaled to fit the cells
+func (cf *colorFont) scaledBitmap(glyph sfnt.GlyphIndex, wide bool, width, height float64) image.Image {
+	key := colorGlyphKey{glyph, wide}
+	if img, ok := cf.scaled[key]; ok {
+		return img
+	}
+
+	src := cf.bitmap(glyph, height)
+	var img image.Image
+	if src != nil {
+		b := src.Bounds()
+		scale := math.Min(width/float64(b.Dx()), height/float64(b.Dy()))
+		w := int(math.Max(1, math.Round(float64(b.Dx())*scale)))
+		h := int(math.Max(1, math.Round(float64(b.Dy())*scale)))
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+		img = dst
+	}
+	cf.scaled[key] = img
+	return img
+}
+
+// bitmap decodes the PNG image of a glyph from the strike closest to ppem,
+// or returns nil if the glyph has no bitmap
+func (cf *colorFont) bitmap(glyph sfnt.GlyphIndex, ppem float64) image.Image {
+	data := cf.bitmapData(glyph, ppem)
+	if data == nil {
+		return nil
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// bitmapData returns the PNG data of a glyph from the CBDT or sbix table
+func (cf *colorFont) bitmapData(glyph sfnt.GlyphIndex, ppem float64) []byte {
+	if data := cbdtGlyph(cf.cblc, cf.cbdt, int(glyph), ppem); data != nil {
+		return data
+	}
+	return sbixGlyph(cf.sbix, int(glyph), ppem)
+}
+
+// pickStrike returns which of the strikes to use for a size: the smallest
+// one at least as big, else the biggest. Strikes with a ppem of 0 don't
+// have the glyph.
+func pickStrike(ppems []int, ppem float64) int {
+	best := -1
+	for i, p := range ppems {
+		if p == 0 {
+			continue
+		}
+		switch {
+		case best == -1:
+			best = i
+		case float64(ppems[best]) < ppem:
+			if p > ppems[best] {
+				best = i
+			}
+		case float64(p) >= ppem && p < ppems[best]:
+			best = i
+		}
+	}
+	return best
+}
+
+// cbdtGlyph returns the PNG data of a glyph from the CBLC and CBDT tables
+func cbdtGlyph(cblc, cbdt []byte, glyph int, ppem float64) []byte {
+	if cblc == nil || cbdt == nil {
+		return nil
+	}
+
+	// Strikes covering the glyph
+	numSizes := sfntUint32(cblc, 4)
+	if 8+48*numSizes > len(cblc) {
+		return nil
+	}
+	ppems := make([]int, numSizes)
+	for i := range ppems {
+		rec := 8 + 48*i
+		if glyph >= sfntUint16(cblc, rec+40) && glyph <= sfntUint16(cblc, rec+42) {
+			ppems[i] = int(sfntUint8(cblc, rec+45))
+		}
+	}
+	strike := pickStrike(ppems, ppem)
+	if strike == -1 {
+		return nil
+	}
+	rec := 8 + 48*strike
+	arrayOffset := sfntUint32(cblc, rec)
+	numSubtables := sfntUint32(cblc, rec+8)
+
+	for i := 0; i < numSubtables; i++ {
+		entry := arrayOffset + 8*i
+		first, last := sfntUint16(cblc, entry), sfntUint16(cblc, entry+2)
+		if glyph < first || glyph > last {
+			continue
+		}
+		sub := arrayOffset + sfntUint32(cblc, entry+4)
+		indexFormat := sfntUint16(cblc, sub)
+		imageFormat := sfntUint16(cblc, sub+2)
+		imageOffset := sfntUint32(cblc, sub+4)
+
+		var start, end int
+		switch indexFormat {
+		case 1:
+			start = sfntUint32(cblc, sub+8+4*(glyph-first))
+			end = sfntUint32(cblc, sub+12+4*(glyph-first))
+		case 2:
+			size := sfntUint32(cblc, sub+8)
+			start = size * (glyph - first)
+			end = start + size
+		case 3:
+			start = sfntUint16(cblc, sub+8+2*(glyph-first))
+			end = sfntUint16(cblc, sub+10+2*(glyph-first))
+		case 4:
+			numGlyphs := sfntUint32(cblc, sub+8)
+			for j := 0; j < numGlyphs; j++ {
+				pair := sub + 12 + 4*j
+				if sfntUint16(cblc, pair) == glyph {
+					start, end = sfntUint16(cblc, pair+2), sfntUint16(cblc, pair+6)
+					break
+				}
+			}
+		case 5:
+			size := sfntUint32(cblc, sub+8)
+			numGlyphs := sfntUint32(cblc, sub+20)
+			for j := 0; j < numGlyphs; j++ {
+				if sfntUint16(cblc, sub+24+2*j) == glyph {
+					start = size * j
+					end = start + size
+					break
+				}
+			}
+		}
+		data := sfntSlice(cbdt, imageOffset+start, imageOffset+end)
+		if len(data) == 0 {
+			return nil
+		}
+
+		// Image formats 17 to 19 hold PNG data after the glyph metrics
+		switch imageFormat {
+		case 17:
+			return sfntSlice(data, 9, 9+sfntUint32(data, 5))
+		case 18:
+			return sfntSlice(data, 12, 12+sfntUint32(data, 8))
+		case 19:
+			return sfntSlice(data, 4, 4+sfntUint32(data, 0))
+		}
+		return nil
+	}
+	return nil
+}
+
+// sbixGlyph returns the PNG data of a glyph from the sbix table
+func sbixGlyph(sbix []byte, glyph int, ppem float64) []byte {
+	if sbix == nil {
+		return nil
+	}
+	numStrikes := sfntUint32(sbix, 4)
+	if 8+4*numStrikes > len(sbix) {
+		return nil
+	}
+	ppems := make([]int, numStrikes)
+	offsets := make([]int, numStrikes)
+	for i := range ppems {
+		offsets[i] = sfntUint32(sbix, 8+4*i)
+		// Only strikes with an image for the glyph
+		start := sfntUint32(sbix, offsets[i]+4+4*glyph)
+		end := sfntUint32(sbix, offsets[i]+8+4*glyph)
+		if end > start {
+			ppems[i] = sfntUint16(sbix, offsets[i])
+		}
+	}
+	strike := pickStrike(ppems, ppem)
+	if strike == -1 {
+		return nil
+	}
+
+	for dupes := 0; dupes < 2; dupes++ {
+		offset := offsets[strike]
+		data := sfntSlice(sbix, offset+sfntUint32(sbix, offset+4+4*glyph), offset+sfntUint32(sbix, offset+8+4*glyph))
+		if len(data) < 8 {
+			return nil
+		}
+		switch string(data[4:8]) {
+		case "png ":
+			return data[8:]
+		case "dupe":
+			glyph = sfntUint16(data, 8)
+			continue
+		}
+		return nil
+	}
+	return nil
+}
+
+// colorLayer is an outline glyph drawn in a single color
+type colorLayer struct {
+	glyph      sfnt.GlyphIndex
+	color      color.RGBA
+	foreground bool // drawn in the text color
+}
+
+// layers returns the layers of a glyph from the COLR and CPAL tables
+func (cf *colorFont) layers(glyph sfnt.GlyphIndex) []colorLayer {
+	if cf.colr == nil || cf.cpal == nil {
+		return nil
+	}
+	numBase := sfntUint16(cf.colr, 2)
+	baseOffset := sfntUint32(cf.colr, 4)
+	layerOffset := sfntUint32(cf.colr, 8)
+
+	// Base glyph records are sorted by glyph
+	lo, hi := 0, numBase
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if sfntUint16(cf.colr, baseOffset+6*mid) < int(glyph) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	rec := baseOffset + 6*lo
+	if lo == numBase || sfntUint16(cf.colr, rec) != int(glyph) {
+		return nil
+	}
+	first, count := sfntUint16(cf.colr, rec+2), sfntUint16(cf.colr, rec+4)
+
+	// Colors of the first palette
+	numEntries := sfntUint16(cf.cpal, 2)
+	colorsOffset := sfntUint32(cf.cpal, 8)
+	paletteStart := sfntUint16(cf.cpal, 12)
+
+	layers := make([]colorLayer, 0, count)
+	for i := first; i < first+count; i++ {
+		layer := layerOffset + 4*i
+		l := colorLayer{glyph: sfnt.GlyphIndex(sfntUint16(cf.colr, layer))}
+		index := sfntUint16(cf.colr, layer+2)
+		if index >= numEntries {
+			// 0xFFFF is the text color
+			l.foreground = true
+		} else {
+			c := colorsOffset + 4*(paletteStart+index)
+			// Color records are BGRA, not premultiplied
+			nrgba := color.NRGBA{
+				R: uint8(sfntUint8(cf.cpal, c+2)),
+				G: uint8(sfntUint8(cf.cpal, c+1)),
+				B: uint8(sfntUint8(cf.cpal, c)),
+				A: uint8(sfntUint8(cf.cpal, c+3)),
+			}
+			l.color = color.RGBAModel.Convert(nrgba).(color.RGBA)
+		}
+		layers = append(layers, l)
+	}
+	return layers
+}
+
+// sfntUint8 reads a byte of a font table, 0 if out of range
+func sfntUint8(b []byte, offset int) int {
+	if offset < 0 || offset >= len(b) {
+		return 0
+	}
+	return int(b[offset])
+}
+
+// sfntUint16 reads a big-endian uint16 of a font table, 0 if out of range
+func sfntUint16(b []byte, offset int) int {
+	if offset < 0 || offset+2 > len(b) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b[offset:]))
+}
+
+// sfntUint32 reads a big-endian uint32 of a font table, 0 if out of range
+func sfntUint32(b []byte, offset int) int {
+	if offset < 0 || offset+4 > len(b) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b[offset:]))
+}
+
+// sfntSlice returns part of a font table, nil if out of range
+func sfntSlice(b []byte, start, end int) []byte {
+	if start < 0 || end > len(b) || start > end {
+		return nil
+	}
+	return b[start:end]
+}
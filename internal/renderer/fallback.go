@@ -0,0 +1,263 @@
+package renderer
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// fallbackFont is a font used for glyphs missing from the primary font
+type fallbackFont struct {
+	path string
+	font *sfnt.Font
+	face font.Face
+}
+
+// fontExtensions are the font file types that can be loaded
+var fontExtensions = map[string]bool{
+	".ttf": true,
+	".otf": true,
+	".ttc": true,
+}
+
+// fontDirs returns the directories searched for system fonts
+func fontDirs() []string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{
+			"/System/Library/Fonts",
+			"/Library/Fonts",
+			filepath.Join(home, "Library/Fonts"),
+		}
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("WINDIR"), "Fonts"),
+			filepath.Join(os.Getenv("LOCALAPPDATA"), "Microsoft/Windows/Fonts"),
+		}
+	default:
+		dirs := []string{
+			"/usr/share/fonts",
+			"/usr/local/share/fonts",
+			filepath.Join(home, ".local/share/fonts"),
+			filepath.Join(home, ".fonts"),
+		}
+		if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+			dirs = append(dirs, filepath.Join(dataHome, "fonts"))
+		}
+		return dirs
+	}
+}
+
+// systemFontFiles lists the font files in the system font directories,
+// monospace and symbol fonts first
+func systemFontFiles() []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, dir := range fontDirs() {
+		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if fontExtensions[strings.ToLower(filepath.Ext(path))] && !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+
+	rank := func(path string) int {
+		name := strings.ToLower(filepath.Base(path))
+		switch {
+		case strings.Contains(name, "mono"):
+			return 0
+		case strings.Contains(name, "symbol"), strings.Contains(name, "nerd"):
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		ri, rj := rank(files[i]), rank(files[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return files[i] < files[j]
+	})
+	return files
+}
+
+// parseFontFile parses a font file, every font of a collection included
+func parseFontFile(path string) ([]*sfnt.Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	var fonts []*sfnt.Font
+	for i := 0; i < collection.NumFonts(); i++ {
+		f, err := collection.Font(i)
+		if err != nil {
+			continue
+		}
+		fonts = append(fonts, f)
+	}
+	return fonts, nil
+}
+
+// hasGlyph reports whether a font has an outline for the rune
+func hasGlyph(f *sfnt.Font, ch rune) bool {
+	var buf sfnt.Buffer
+	index, err := f.GlyphIndex(&buf, ch)
+	if err != nil || index == 0 {
+		return false
+	}
+	// Bitmap-only fonts (color emoji) have no outlines to draw
+	_, err = f.LoadGlyph(&buf, index, fixed.I(16), nil)
+	return err == nil
+}
+
+// primaryHasGlyph reports whether the primary font can draw the rune
+func (r *Renderer) primaryHasGlyph(ch rune) bool {
+	if r.primary == nil {
+		return false
+	}
+	if covered, ok := r.primaryCoverage[ch]; ok {
+		return covered
+	}
+	covered := hasGlyph(r.primary, ch)
+	r.primaryCoverage[ch] = covered
+	return covered
+}
+
+// fallbackFor returns the first fallback font that has the rune: the
+// configured fallbacks first, then the system fonts
+func (r *Renderer) fallbackFor(ch rune) *fallbackFont {
+	if fb, ok := r.fallbackCache[ch]; ok {
+		return fb
+	}
+
+	fb := r.searchFallbacks(ch)
+	r.fallbackCache[ch] = fb
+	return fb
+}
+
+// searchFallbacks looks for a font with the rune: the fallbacks loaded so
+// far, then the font files in the queue, the configured fallbacks first and
+// then the system fonts. Files are only loaded if they have the rune, so a
+// rune no font has costs a scan of the files but no memory.
+func (r *Renderer) searchFallbacks(ch rune) *fallbackFont {
+	for _, fb := range r.fallbacks {
+		if hasGlyph(fb.font, ch) {
+			return fb
+		}
+	}
+
+	for {
+		for i := 0; i < len(r.fallbackQueue); i++ {
+			path := r.fallbackQueue[i]
+			found := false
+			if !r.loadedFonts[path] {
+				var err error
+				if found, err = fileHasGlyph(path, ch); err == nil && !found {
+					continue // may have later runes
+				}
+			}
+
+			// Loaded, unreadable or about to be loaded: out of the queue
+			r.fallbackQueue = slices.Delete(r.fallbackQueue, i, i+1)
+			i--
+			if !found {
+				continue
+			}
+			r.loadedFonts[path] = true
+			if fb := r.loadFallback(path, ch); fb != nil {
+				return fb
+			}
+		}
+
+		// Configured fallbacks are exhausted, continue with the system fonts
+		if r.discoveryStarted {
+			return nil
+		}
+		r.discoveryStarted = true
+		r.fallbackQueue = append(r.fallbackQueue, systemFontFiles()...)
+	}
+}
+
+// fileHasGlyph reports whether a font of a file has the rune. Only the
+// tables needed to tell are read.
+func fileHasGlyph(path string, ch rune) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	collection, err := sfnt.ParseCollectionReaderAt(f)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < collection.NumFonts(); i++ {
+		font, err := collection.Font(i)
+		if err == nil && hasGlyph(font, ch) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadFallback loads the fonts of a file as fallbacks and returns the
+// first one that has the rune
+func (r *Renderer) loadFallback(path string, ch rune) *fallbackFont {
+	fonts, err := parseFontFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var found *fallbackFont
+	for _, f := range fonts {
+		face, err := r.newFace(f)
+		if err != nil {
+			continue
+		}
+		fb := &fallbackFont{path: path, font: f, face: face}
+		r.fallbacks = append(r.fallbacks, fb)
+		if found == nil && hasGlyph(f, ch) {
+			found = fb
+		}
+	}
+	return found
+}
+
+// drawFallbackChar draws a character from a fallback font, fitted to the cell
+func (r *Renderer) drawFallbackChar(dc *gg.Context, fb *fallbackFont, cell ScreenCell, x, y float64) {
+	dc.SetFontFace(fb.face)
+	marks := clusterMarks(cell, fb.font)
+
+	dc.Push()
+	defer dc.Pop()
+	if cell.Italic {
+		dc.ShearAbout(-0.2, 0, x, y)
+	}
+
+	// Center narrow glyphs in the cell and squeeze wide ones into it
+	x = r.fitGlyph(dc, fb.face, cell, x, y)
+
+	drawCluster(dc, fb.face, cell.Char, marks, x, y)
+	if cell.Bold {
+		drawCluster(dc, fb.face, cell.Char, marks, x+r.scale, y)
+	}
+}
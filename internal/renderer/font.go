@@ -0,0 +1,385 @@
+package renderer
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// fontStyle identifies a face of a font family
+type fontStyle int
+
+const (
+	styleRegular fontStyle = iota
+	styleBold
+	styleItalic
+	styleBoldItalic
+)
+
+// styleFor returns the font style for the given attributes
+func styleFor(bold, italic bool) fontStyle {
+	switch {
+	case bold && italic:
+		return styleBoldItalic
+	case bold:
+		return styleBold
+	case italic:
+		return styleItalic
+	default:
+		return styleRegular
+	}
+}
+
+// fontRef identifies a font in a font file or collection
+type fontRef struct {
+	path     string
+	index    int
+	embedded bool // path names one of the bundled fonts
+}
+
+// fontInfo is a font found in the system font directories
+type fontInfo struct {
+	ref       fontRef
+	family    string
+	subfamily string
+}
+
+// monospaceFamilies are tried in order when theme.font is "monospace"
+var monospaceFamilies = []string{
+	"DejaVu Sans Mono",
+	"Liberation Mono",
+	"Ubuntu Mono",
+	"JetBrains Mono",
+	"Fira Code",
+	"Fira Mono",
+	"Source Code Pro",
+	"Noto Sans Mono",
+	"Cascadia Code",
+	"Menlo",
+	"Monaco",
+	"SF Mono",
+	"Consolas",
+	"Courier New",
+}
+
+// fontVariants maps the regular suffix of known font files to the
+// suffixes of their bold, italic and bold italic variants
+var fontVariants = []struct {
+	regular string
+	styles  map[fontStyle]string
+}{
+	{"-Regular", map[fontStyle]string{styleBold: "-Bold", styleItalic: "-Italic", styleBoldItalic: "-BoldItalic"}},
+	{"-R", map[fontStyle]string{styleBold: "-B", styleItalic: "-RI", styleBoldItalic: "-BI"}},
+	{"", map[fontStyle]string{styleBold: "-Bold", styleItalic: "-Oblique", styleBoldItalic: "-BoldOblique"}},
+}
+
+// isFontPath reports whether theme.font names a file rather than a family
+func isFontPath(name string) bool {
+	return strings.ContainsAny(name, `/\`) || fontExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// resolveFont finds the faces of theme.font, a file path or a family name,
+// and returns them with the family name. Without a font the bundled one is used.
+func resolveFont(name, style string) (map[fontStyle]fontRef, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || fontKey(name) == fontKey(embeddedFamily) {
+		return familyStyles(embeddedFonts, embeddedFamily, style)
+	}
+	if isFontPath(name) {
+		if _, err := os.Stat(name); err != nil {
+			return nil, "", fmt.Errorf("font file %s not found", name)
+		}
+		styles := findFontStyles(name)
+		return styles, fontFamily(styles[styleRegular]), nil
+	}
+
+	fonts := systemFonts()
+	if strings.EqualFold(name, "monospace") {
+		for _, family := range monospaceFamilies {
+			if styles, family, err := familyStyles(fonts, family, style); err == nil {
+				return styles, family, nil
+			}
+		}
+		// Any monospace family will do
+		for _, info := range fonts {
+			if strings.Contains(strings.ToLower(info.family), "mono") {
+				if styles, family, err := familyStyles(fonts, info.family, style); err == nil {
+					return styles, family, nil
+				}
+			}
+		}
+		return familyStyles(embeddedFonts, embeddedFamily, style)
+	}
+
+	return familyStyles(fonts, name, style)
+}
+
+// resolveFallback finds a fallback font, a file path or a family name
+func resolveFallback(name string) (fontRef, error) {
+	name = strings.TrimSpace(name)
+	if isFontPath(name) {
+		if _, err := os.Stat(name); err != nil {
+			return fontRef{}, fmt.Errorf("font file %s not found", name)
+		}
+		return fontRef{path: name}, nil
+	}
+	styles, _, err := familyStyles(systemFonts(), name, "")
+	if err != nil {
+		return fontRef{}, err
+	}
+	return styles[styleRegular], nil
+}
+
+// familyStyles picks the regular, bold, italic and bold italic faces of a family
+func familyStyles(fonts []fontInfo, family, style string) (map[fontStyle]fontRef, string, error) {
+	key := fontKey(family)
+	var faces []fontInfo
+	for _, info := range fonts {
+		if fontKey(info.family) == key {
+			faces = append(faces, info)
+		}
+	}
+	if len(faces) == 0 {
+		return nil, "", fmt.Errorf("font %q not found in %s", family, strings.Join(fontDirs(), ", "))
+	}
+
+	pick := func(subfamily string) (fontRef, bool) {
+		for _, info := range faces {
+			if normalizeSubfamily(info.subfamily) == normalizeSubfamily(subfamily) {
+				return info.ref, true
+			}
+		}
+		return fontRef{}, false
+	}
+
+	styles := map[fontStyle]fontRef{}
+	regular, ok := pick(style)
+	if !ok {
+		if style != "" {
+			var available []string
+			for _, info := range faces {
+				available = append(available, info.subfamily)
+			}
+			return nil, "", fmt.Errorf("font %q has no style %q (available: %s)", family, style, strings.Join(available, ", "))
+		}
+		regular = faces[0].ref
+	}
+	styles[styleRegular] = regular
+
+	if ref, ok := pick("Bold"); ok {
+		styles[styleBold] = ref
+	}
+	if ref, ok := pick(style + " Italic"); ok {
+		styles[styleItalic] = ref
+	} else if ref, ok := pick("Italic"); ok {
+		styles[styleItalic] = ref
+	}
+	if ref, ok := pick("Bold Italic"); ok {
+		styles[styleBoldItalic] = ref
+	}
+	return styles, faces[0].family, nil
+}
+
+// fontKey normalizes a family name for matching
+func fontKey(family string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(family))
+}
+
+// normalizeSubfamily normalizes a style name for matching
+func normalizeSubfamily(subfamily string) string {
+	s := fontKey(subfamily)
+	s = strings.ReplaceAll(s, "oblique", "italic")
+	switch s {
+	case "", "book", "normal", "roman", "regular":
+		return "regular"
+	case "regularitalic", "bookitalic":
+		return "italic"
+	}
+	return s
+}
+
+// System fonts are only scanned once
+var (
+	systemFontsOnce sync.Once
+	systemFontList  []fontInfo
+)
+
+// systemFonts reads the family and style names of the fonts in the
+// system font directories
+func systemFonts() []fontInfo {
+	systemFontsOnce.Do(func() {
+		for _, path := range systemFontFiles() {
+			systemFontList = append(systemFontList, readFontNames(path)...)
+		}
+	})
+	return systemFontList
+}
+
+// readFontNames reads the names of the fonts in a font file or collection
+func readFontNames(path string) []fontInfo {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var fonts []*sfnt.Font
+	if collection, err := sfnt.ParseCollectionReaderAt(file); err == nil {
+		for i := 0; i < collection.NumFonts(); i++ {
+			f, err := collection.Font(i)
+			if err != nil {
+				break
+			}
+			fonts = append(fonts, f)
+		}
+	}
+
+	var infos []fontInfo
+	var buf sfnt.Buffer
+	for i, f := range fonts {
+		ref := fontRef{path: path, index: i}
+		family, _ := f.Name(&buf, sfnt.NameIDFamily)
+		subfamily, _ := f.Name(&buf, sfnt.NameIDSubfamily)
+		if family != "" {
+			infos = append(infos, fontInfo{ref, family, subfamily})
+		}
+		// Families with more than four styles also have a typographic name,
+		// e.g. "JetBrains Mono" "Medium" next to "JetBrains Mono Medium" "Regular"
+		typoFamily, _ := f.Name(&buf, sfnt.NameIDTypographicFamily)
+		typoSubfamily, _ := f.Name(&buf, sfnt.NameIDTypographicSubfamily)
+		if typoFamily != "" && (typoFamily != family || typoSubfamily != subfamily) {
+			infos = append(infos, fontInfo{ref, typoFamily, typoSubfamily})
+		}
+	}
+	return infos
+}
+
+// fontFamily returns the family name of a font file
+func fontFamily(ref fontRef) string {
+	for _, info := range readFontNames(ref.path) {
+		if info.ref == ref {
+			return info.family
+		}
+	}
+	return ""
+}
+
+// findFontStyles looks for the style variants of a font file next to it
+func findFontStyles(regularPath string) map[fontStyle]fontRef {
+	styles := map[fontStyle]fontRef{}
+	if regularPath == "" {
+		return styles
+	}
+	styles[styleRegular] = fontRef{path: regularPath}
+
+	dot := strings.LastIndex(regularPath, ".")
+	if dot == -1 {
+		return styles
+	}
+	base, ext := regularPath[:dot], regularPath[dot:]
+
+	for _, v := range fontVariants {
+		if !strings.HasSuffix(base, v.regular) {
+			continue
+		}
+		stem := strings.TrimSuffix(base, v.regular)
+		for style, suffix := range v.styles {
+			p := stem + suffix + ext
+			if _, err := os.Stat(p); err == nil {
+				styles[style] = fontRef{path: p}
+			}
+		}
+		if len(styles) > 1 {
+			break
+		}
+	}
+
+	return styles
+}
+
+// loadFont parses the font a reference points to
+func loadFont(ref fontRef) (*sfnt.Font, error) {
+	data, err := readFontData(ref)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref.path, err)
+	}
+	if ref.index >= collection.NumFonts() {
+		return nil, fmt.Errorf("%s: no font %d in collection", ref.path, ref.index)
+	}
+	return collection.Font(ref.index)
+}
+
+// newFace creates a face of a font at the renderer's font size
+func (r *Renderer) newFace(f *sfnt.Font) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size: r.fontSize,
+		DPI:  72,
+	})
+}
+
+// loadFaces loads a face for each available font style
+func (r *Renderer) loadFaces() map[fontStyle]font.Face {
+	faces := map[fontStyle]font.Face{}
+	for style, f := range r.fonts {
+		face, err := r.newFace(f)
+		if err != nil {
+			continue
+		}
+		faces[style] = face
+	}
+	return faces
+}
+
+// measure sets the cell size and baseline from the metrics of the primary
+// font, so every output format lays out text the same way
+func (r *Renderer) measure(lineHeight float64) {
+	if lineHeight <= 0 {
+		lineHeight = 1
+	}
+
+	// Without a font, use typical monospace proportions
+	advance, ascent, descent, height := r.fontSize*0.6, r.fontSize*0.95, r.fontSize*0.25, r.fontSize*1.2
+	r.xHeight = r.fontSize * 0.5
+	if r.primary != nil {
+		var buf sfnt.Buffer
+		ppem := fixed.Int26_6(math.Round(r.fontSize * 64))
+		if m, err := r.primary.Metrics(&buf, ppem, font.HintingNone); err == nil {
+			ascent, descent, height = fromFixed(m.Ascent), fromFixed(m.Descent), fromFixed(m.Height)
+			if m.XHeight > 0 {
+				r.xHeight = fromFixed(m.XHeight)
+			}
+		}
+		if index, err := r.primary.GlyphIndex(&buf, '0'); err == nil && index != 0 {
+			if a, err := r.primary.GlyphAdvance(&buf, index, ppem, font.HintingNone); err == nil {
+				advance = fromFixed(a)
+			}
+		}
+	}
+	if height < ascent+descent {
+		height = ascent + descent
+	}
+
+	// Whole pixels keep cell edges sharp, so box-drawing lines join up
+	r.charWidth = math.Max(1, math.Round(advance))
+	r.charHeight = math.Max(1, math.Round(height*lineHeight))
+
+	// Extra spacing is split evenly above and below the glyphs
+	r.baseline = math.Round((r.charHeight-ascent-descent)/2 + ascent)
+}
+
+// fromFixed converts a 26.6 fixed-point value to pixels
+func fromFixed(v fixed.Int26_6) float64 {
+	return float64(v) / 64
+}
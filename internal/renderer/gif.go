@@ -0,0 +1,421 @@
+package renderer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Frame is a screen state shown for a while in an animation
+type Frame struct {
+	Buffer *ScreenBuffer
+	Delay  time.Duration
+}
+
+// RenderGIF renders frames to an animated GIF file. loop is the GIF loop
+// count: 0 loops forever, -1 plays once, n repeats n times. Frames are
+// rendered twice, once for the palette and once to encode them, so only a
+// couple of them are in memory at a time.
+func (r *Renderer) RenderGIF(frames []Frame, outputPath string, loop int) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to render")
+	}
+
+	// One palette shared by all frames, so unchanged pixels keep their index
+	var histogram colorHistogram
+	var bounds image.Rectangle
+	for i, frame := range frames {
+		img := r.RenderImage(frame.Buffer)
+		if i == 0 {
+			bounds = img.Bounds()
+		}
+		histogram.add(img)
+	}
+	palette := histogram.palette(256)
+	mapper := newColorMapper(palette)
+
+	// Transparent pixels would show the previous frame, so frames with
+	// transparency replace each other entirely
+	disposal := byte(gif.DisposalNone)
+	if mapper.transparent != -1 {
+		disposal = gif.DisposalBackground
+	}
+
+	// Ensure output directory exists
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := &gifWriter{
+		w:    bufio.NewWriter(f),
+		loop: loop,
+		config: image.Config{
+			ColorModel: palette,
+			Width:      bounds.Dx(),
+			Height:     bounds.Dy(),
+		},
+	}
+
+	// A frame is written once the next one differs, as unchanged frames
+	// add their delay to it
+	var prev, pending *image.Paletted
+	pendingDelay := 0
+	for i := range frames {
+		delay := gifDelay(frames[i].Delay)
+		frame := mapper.paletted(r.RenderImage(frames[i].Buffer))
+
+		// Only store the part that changed since the previous frame
+		rect := frame.Bounds()
+		if prev != nil {
+			changed := changedBounds(prev, frame)
+			if changed.Empty() {
+				pendingDelay += delay
+				continue
+			}
+			if disposal == gif.DisposalNone {
+				rect = changed
+			}
+		}
+
+		if pending != nil {
+			if err := w.writeFrame(pending, pendingDelay, disposal, true); err != nil {
+				return err
+			}
+		}
+		pending, pendingDelay = frame.SubImage(rect).(*image.Paletted), delay
+		prev = frame
+	}
+	if err := w.writeFrame(pending, pendingDelay, disposal, false); err != nil {
+		return err
+	}
+
+	if err := w.close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// gifWriter writes an animated GIF a frame at a time. All frames use the
+// global palette of the config.
+type gifWriter struct {
+	w      *bufio.Writer
+	config image.Config
+	loop   int
+	frames int
+}
+
+// writeFrame writes a frame, preceded by the GIF header for the first one.
+// more tells whether frames follow, which decides if the loop count is
+// written, as gif.EncodeAll does.
+func (g *gifWriter) writeFrame(img *image.Paletted, delay int, disposal byte, more bool) error {
+	// Encode a GIF of just this frame: a header, the frame and a trailer
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:    []*image.Paletted{img},
+		Delay:    []int{delay},
+		Disposal: []byte{disposal},
+		Config:   g.config,
+	})
+	if err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	header := 13 // signature and logical screen descriptor
+	if data[10]&0x80 != 0 {
+		header += 3 << (data[10]&0x07 + 1) // global color table
+	}
+
+	// Write errors stick to the bufio.Writer and come back from the last write
+	if g.frames == 0 {
+		g.w.Write(data[:header])
+		if more && g.loop >= 0 {
+			g.w.WriteString("\x21\xff\x0bNETSCAPE2.0")
+			g.w.Write([]byte{0x03, 0x01, byte(g.loop), byte(g.loop >> 8), 0x00})
+		}
+	}
+	g.frames++
+	_, err = g.w.Write(data[header : len(data)-1])
+	return err
+}
+
+// close writes the GIF trailer
+func (g *gifWriter) close() error {
+	g.w.WriteByte(0x3b)
+	return g.w.Flush()
+}
+
+// gifDelay converts a duration to GIF delay units (1/100 s)
+func gifDelay(d time.Duration) int {
+	delay := int(d / (10 * time.Millisecond))
+	if delay < 2 {
+		delay = 2 // browsers slow down shorter delays
+	}
+	return delay
+}
+
+// changedBounds returns the smallest rectangle containing all pixels that
+// differ between two frames
+func changedBounds(a, b *image.Paletted) image.Rectangle {
+	bounds := b.Bounds()
+	minX, minY, maxX, maxY := bounds.Max.X, bounds.Max.Y, bounds.Min.X, bounds.Min.Y
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		rowA := a.Pix[a.PixOffset(bounds.Min.X, y):a.PixOffset(bounds.Max.X, y)]
+		rowB := b.Pix[b.PixOffset(bounds.Min.X, y):b.PixOffset(bounds.Max.X, y)]
+		for x := range rowB {
+			if rowA[x] == rowB[x] {
+				continue
+			}
+			px := bounds.Min.X + x
+			if px < minX {
+				minX = px
+			}
+			if px+1 > maxX {
+				maxX = px + 1
+			}
+			if y < minY {
+				minY = y
+			}
+			maxY = y + 1
+		}
+	}
+	if maxX <= minX {
+		return image.Rectangle{} // image.Rect would swap the corners
+	}
+	return image.Rect(minX, minY, maxX, maxY).Intersect(bounds)
+}
+
+// colorCount is a color and how many pixels use it
+type colorCount struct {
+	rgb   [3]uint8
+	count int
+}
+
+// colorHistogram counts the pixels of each color of a set of images
+type colorHistogram struct {
+	counts      map[[3]uint8]int
+	transparent bool
+}
+
+// add counts the colors of an image
+func (h *colorHistogram) add(img image.Image) {
+	if h.counts == nil {
+		h.counts = map[[3]uint8]int{}
+	}
+	rgba := toRGBA(img)
+	var last [3]uint8
+	run := 0
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		if rgba.Pix[i+3] < 128 {
+			h.transparent = true
+			continue
+		}
+		c := [3]uint8{rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]}
+		if run > 0 && c == last {
+			run++
+			continue
+		}
+		if run > 0 {
+			h.counts[last] += run
+		}
+		last, run = c, 1
+	}
+	if run > 0 {
+		h.counts[last] += run
+	}
+}
+
+// palette builds a palette of at most size colors for the counted images
+// using median cut. Transparent pixels get their own palette entry.
+func (h *colorHistogram) palette(size int) color.Palette {
+	if h.transparent {
+		size--
+	}
+	colors := make([]colorCount, 0, len(h.counts))
+	for rgb, count := range h.counts {
+		colors = append(colors, colorCount{rgb, count})
+	}
+
+	var palette color.Palette
+	for _, box := range medianCut(colors, size) {
+		palette = append(palette, box.average())
+	}
+	if h.transparent {
+		palette = append(palette, color.RGBA{})
+	}
+	if len(palette) == 0 {
+		palette = append(palette, color.RGBA{0, 0, 0, 255})
+	}
+	return palette
+}
+
+// colorBox is a set of colors that ends up as one palette entry
+type colorBox []colorCount
+
+// medianCut splits the colors into at most size boxes
+func medianCut(colors []colorCount, size int) []colorBox {
+	if len(colors) == 0 {
+		return nil
+	}
+	boxes := []colorBox{colors}
+	for len(boxes) < size {
+		// Split the box with the widest channel range, weighted by pixel count
+		best, bestScore, bestChannel := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			channel, spread := box.widestChannel()
+			score := spread * box.pixels()
+			if score > bestScore {
+				best, bestScore, bestChannel = i, score, channel
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i].rgb[bestChannel] < box[j].rgb[bestChannel] })
+		half, seen := box.pixels()/2, 0
+		split := 1
+		for i, c := range box[:len(box)-1] {
+			seen += c.count
+			split = i + 1
+			if seen >= half {
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+	return boxes
+}
+
+// widestChannel returns the channel with the largest range in the box
+func (b colorBox) widestChannel() (int, int) {
+	channel, spread := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, c := range b {
+			v := int(c.rgb[ch])
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > spread {
+			channel, spread = ch, hi-lo
+		}
+	}
+	return channel, spread
+}
+
+// pixels returns the number of pixels using the colors of the box
+func (b colorBox) pixels() int {
+	n := 0
+	for _, c := range b {
+		n += c.count
+	}
+	return n
+}
+
+// average returns the pixel-weighted average color of the box
+func (b colorBox) average() color.RGBA {
+	var r, g, bl, n int
+	for _, c := range b {
+		r += int(c.rgb[0]) * c.count
+		g += int(c.rgb[1]) * c.count
+		bl += int(c.rgb[2]) * c.count
+		n += c.count
+	}
+	if n == 0 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 255}
+}
+
+// colorMapper maps colors to their nearest palette entry, caching lookups
+type colorMapper struct {
+	palette     color.Palette
+	cache       map[[3]uint8]uint8
+	transparent int
+}
+
+// newColorMapper creates a mapper for the palette
+func newColorMapper(palette color.Palette) *colorMapper {
+	m := &colorMapper{
+		palette:     palette,
+		cache:       map[[3]uint8]uint8{},
+		transparent: -1,
+	}
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			m.transparent = i
+		}
+	}
+	return m
+}
+
+// paletted converts an image to the mapper's palette
+func (m *colorMapper) paletted(img image.Image) *image.Paletted {
+	rgba := toRGBA(img)
+	out := image.NewPaletted(rgba.Bounds(), m.palette)
+	for i, j := 0, 0; i < len(rgba.Pix); i, j = i+4, j+1 {
+		if rgba.Pix[i+3] < 128 && m.transparent != -1 {
+			out.Pix[j] = uint8(m.transparent)
+			continue
+		}
+		out.Pix[j] = m.index([3]uint8{rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]})
+	}
+	return out
+}
+
+// index returns the palette index of the color nearest to rgb
+func (m *colorMapper) index(rgb [3]uint8) uint8 {
+	if i, ok := m.cache[rgb]; ok {
+		return i
+	}
+	best, bestDist := 0, -1
+	for i, c := range m.palette {
+		if i == m.transparent {
+			continue
+		}
+		pr, pg, pb, _ := c.RGBA()
+		dr := int(rgb[0]) - int(pr>>8)
+		dg := int(rgb[1]) - int(pg>>8)
+		db := int(rgb[2]) - int(pb>>8)
+		dist := dr*dr + dg*dg + db*db
+		if bestDist == -1 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	m.cache[rgb] = uint8(best)
+	return uint8(best)
+}
+
+// toRGBA returns the image as an RGBA image starting at 0,0
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) && rgba.Stride == 4*rgba.Rect.Dx() {
+		return rgba
+	}
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Src)
+	return out
+}
@@ -0,0 +1,114 @@
+package renderer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// maxCachedGlyphs bounds the glyph cache, which is cleared when full. Only
+// output with many true colors (gradients) gets there.
+const maxCachedGlyphs = 8192
+
+// glyphKey identifies a rendered character in the glyph cache
+type glyphKey struct {
+	text         string // the character and its combining marks
+	bold, italic bool
+	wide         bool
+	color        color.NRGBA
+}
+
+// glyphImage is a character rendered once and copied into every cell
+// showing it
+type glyphImage struct {
+	img    *image.RGBA // nil if the character draws nothing
+	offset image.Point // position relative to the top-left pixel of the cell
+}
+
+// cachedGlyph returns the image of a character in a color, rendering it the
+// first time it is needed
+func (r *Renderer) cachedGlyph(cell ScreenCell, c color.NRGBA) *glyphImage {
+	key := glyphKey{
+		text:   string(cell.Char) + cell.Combining,
+		bold:   cell.Bold,
+		italic: cell.Italic,
+		wide:   cell.Wide,
+		color:  c,
+	}
+	if g, ok := r.glyphs[key]; ok {
+		return g
+	}
+	if len(r.glyphs) >= maxCachedGlyphs {
+		r.glyphs = map[glyphKey]*glyphImage{}
+	}
+	g := r.renderGlyph(cell, c)
+	r.glyphs[key] = g
+	return g
+}
+
+// renderGlyph draws a character on a transparent canvas with room for
+// parts sticking out of its cells (italics, accents, wide fallback glyphs)
+// and keeps the part that was drawn on
+func (r *Renderer) renderGlyph(cell ScreenCell, c color.NRGBA) *glyphImage {
+	margin := int(math.Ceil(r.charHeight))
+	width := int(math.Ceil(r.cellSpan(cell))) + 2*margin
+	height := int(math.Ceil(r.charHeight)) + 2*margin
+	dc := gg.NewContext(width, height)
+
+	// Cells are whole pixels (see measure), so all of them share the
+	// subpixel offset of the padding
+	_, frac := math.Modf(r.padding())
+	x := float64(margin) + frac
+	top := float64(margin) + frac
+	if isBoxChar(cell.Char) {
+		r.drawBoxChar(ggPen{dc: dc, color: c}, cell.Char, x, top)
+	} else {
+		r.drawStyledChar(dc, cell, c, x, top+r.baseline)
+	}
+
+	src := dc.Image().(*image.RGBA)
+	bounds := opaqueBounds(src)
+	if bounds.Empty() {
+		return &glyphImage{}
+	}
+	img := image.NewRGBA(image.Rectangle{Max: bounds.Size()})
+	draw.Draw(img, img.Bounds(), src, bounds.Min, draw.Src)
+	return &glyphImage{img: img, offset: bounds.Min.Sub(image.Pt(margin, margin))}
+}
+
+// draw copies a cached character into the image with the top-left
+// corner of its cell at x, top
+func (g *glyphImage) draw(dst *image.RGBA, x, top float64) {
+	if g.img == nil {
+		return
+	}
+	at := image.Pt(int(math.Floor(x)), int(math.Floor(top))).Add(g.offset)
+	draw.Draw(dst, g.img.Bounds().Add(at), g.img, image.Point{}, draw.Over)
+}
+
+// opaqueBounds returns the smallest rectangle containing the pixels of an
+// image that aren't fully transparent
+func opaqueBounds(img *image.RGBA) image.Rectangle {
+	bounds := img.Bounds()
+	minX, minY, maxX, maxY := bounds.Max.X, bounds.Max.Y, bounds.Min.X, bounds.Min.Y
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] == 0 {
+				continue
+			}
+			x := bounds.Min.X + i/4
+			minX = min(minX, x)
+			maxX = max(maxX, x+1)
+			minY = min(minY, y)
+			maxY = y + 1
+		}
+	}
+	if maxX <= minX {
+		return image.Rectangle{}
+	}
+	return image.Rectangle{Min: image.Pt(minX, minY), Max: image.Pt(maxX, maxY)}
+}
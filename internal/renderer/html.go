@@ -0,0 +1,287 @@
+package renderer
+
+import (
+	"fmt"
+	"image/color"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// htmlClassPrefix prefixes the classes of class-based HTML output
+const htmlClassPrefix = "eddie"
+
+// htmlCSSFile is the stylesheet written next to class-based HTML output
+const htmlCSSFile = "eddie.css"
+
+// wideCSS keeps a wide character two cells wide
+const wideCSS = "display:inline-block;width:2ch;text-align:center"
+
+// linkSchemes are the URL schemes hyperlinks may use, links to anything
+// else, like javascript:, are rendered as plain text
+var linkSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "file": true}
+
+// spanStyle is the part of a cell that decides how its HTML span is styled
+type spanStyle struct {
+	textStyle
+	bg    color.RGBA
+	hasBG bool
+}
+
+// RenderHTML renders a ScreenBuffer to an HTML snippet, a <pre> with styled
+// <span> runs. With classes set the colors and attributes are CSS classes
+// defined in a generated stylesheet instead of inline styles.
+func (r *Renderer) RenderHTML(buffer *ScreenBuffer, outputPath string, classes bool) error {
+	showCursor, cursorStyle := r.cursor(buffer)
+
+	var b strings.Builder
+	if classes {
+		fmt.Fprintf(&b, `<pre class="%s">`, htmlClassPrefix)
+	} else {
+		fmt.Fprintf(&b, `<pre style="%s">`, r.preStyle())
+	}
+
+	for row, line := range buffer.Lines {
+		if row > 0 {
+			b.WriteString("\n")
+		}
+
+		// Styles of the cells, trailing blank cells are left out
+		styles := make([]spanStyle, len(line.Cells))
+		end := 0
+		for col, cell := range line.Cells {
+			isCursor := showCursor && row == buffer.CursorY && col == buffer.CursorX
+			styles[col] = r.spanStyle(cell, isCursor, cursorStyle)
+			if cell.Char != ' ' || styles[col].hasBG || styles[col].underline || styles[col].strikethrough || safeLink(cell.Link) != "" {
+				end = col + 1
+			}
+		}
+
+		col := 0
+		for col < end {
+			// Run of cells with the same link
+			link := safeLink(line.Cells[col].Link)
+			linkEnd := col + 1
+			for linkEnd < end && safeLink(line.Cells[linkEnd].Link) == link {
+				linkEnd++
+			}
+			if link != "" {
+				fmt.Fprintf(&b, `<a href="%s">`, escapeXML(link))
+			}
+
+			// Runs of cells with the same style
+			for col < linkEnd {
+				style := styles[col]
+				runEnd := col + 1
+				for runEnd < linkEnd && styles[runEnd] == style {
+					runEnd++
+				}
+				r.writeHTMLSpan(&b, line.Cells[col:runEnd], style, classes)
+				col = runEnd
+			}
+
+			if link != "" {
+				b.WriteString("</a>")
+			}
+		}
+	}
+	b.WriteString("</pre>\n")
+
+	// Ensure output directory exists
+	dir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if classes {
+		if err := os.WriteFile(filepath.Join(dir, htmlCSSFile), []byte(r.themeCSS()), 0644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(outputPath, []byte(b.String()), 0644)
+}
+
+// safeLink returns a hyperlink target if its scheme is one of linkSchemes,
+// "" otherwise
+func safeLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || !linkSchemes[u.Scheme] {
+		return ""
+	}
+	return link
+}
+
+// spanStyle returns the HTML style of a cell
+func (r *Renderer) spanStyle(cell ScreenCell, isCursor bool, cursorStyle string) spanStyle {
+	fg, bg := r.cellColors(cell, isCursor, cursorStyle)
+	return spanStyle{
+		textStyle: textStyle{
+			fg:            fg,
+			bold:          cell.Bold,
+			italic:        cell.Italic,
+			underline:     cell.Underline,
+			strikethrough: cell.Strikethrough,
+			dim:           cell.Dim,
+		},
+		bg:    bg,
+		hasBG: bg.A > 0 && (bg != r.palette.Background || isCursor),
+	}
+}
+
+// writeHTMLSpan writes a run of cells, wrapped in a <span> if it has a style
+func (r *Renderer) writeHTMLSpan(b *strings.Builder, cells []ScreenCell, style spanStyle, classes bool) {
+	var text strings.Builder
+	for _, cell := range cells {
+		if cell.Spacer {
+			continue // part of the wide character before it
+		}
+		ch := escapeXML(string(svgRune(cell.Char)) + cell.Combining)
+		if !cell.Wide {
+			text.WriteString(ch)
+			continue
+		}
+		// Keep wide characters exactly two cells wide, whatever font the browser picks
+		if classes {
+			fmt.Fprintf(&text, `<span class="%s-w">%s</span>`, htmlClassPrefix, ch)
+		} else {
+			fmt.Fprintf(&text, `<span style="%s">%s</span>`, wideCSS, ch)
+		}
+	}
+
+	var attr string
+	if classes {
+		attr = r.spanClasses(style)
+		if attr != "" {
+			attr = fmt.Sprintf(` class="%s"`, attr)
+		}
+		// Colors outside the theme palette stay inline
+		if css := r.spanColors(style, true); css != "" {
+			attr += fmt.Sprintf(` style="%s"`, css)
+		}
+	} else if css := r.spanCSS(style); css != "" {
+		attr = fmt.Sprintf(` style="%s"`, css)
+	}
+
+	if attr == "" {
+		b.WriteString(text.String())
+		return
+	}
+	fmt.Fprintf(b, "<span%s>%s</span>", attr, text.String())
+}
+
+// spanCSS returns the inline style of a span
+func (r *Renderer) spanCSS(style spanStyle) string {
+	var css []string
+	if c := r.spanColors(style, false); c != "" {
+		css = append(css, c)
+	}
+	if style.bold {
+		css = append(css, "font-weight:bold")
+	}
+	if style.italic {
+		css = append(css, "font-style:italic")
+	}
+	if d := textDecoration(style.textStyle); d != "" {
+		css = append(css, "text-decoration:"+d)
+	}
+	if style.dim {
+		css = append(css, "opacity:0.5")
+	}
+	return strings.Join(css, ";")
+}
+
+// spanColors returns the inline color style of a span. With paletteClasses
+// set, colors that have a class in the theme stylesheet are left out.
+func (r *Renderer) spanColors(style spanStyle, paletteClasses bool) string {
+	var css []string
+	if style.fg != r.palette.Foreground && !(paletteClasses && r.paletteIndex(style.fg) != -1) {
+		css = append(css, "color:"+hexColor(style.fg))
+	}
+	if style.hasBG && !(paletteClasses && r.paletteIndex(style.bg) != -1) {
+		css = append(css, "background-color:"+hexColor(style.bg))
+	}
+	return strings.Join(css, ";")
+}
+
+// spanClasses returns the classes of a span
+func (r *Renderer) spanClasses(style spanStyle) string {
+	var classes []string
+	if style.fg != r.palette.Foreground {
+		if i := r.paletteIndex(style.fg); i != -1 {
+			classes = append(classes, fmt.Sprintf("%s-fg%d", htmlClassPrefix, i))
+		}
+	}
+	if style.hasBG {
+		if i := r.paletteIndex(style.bg); i != -1 {
+			classes = append(classes, fmt.Sprintf("%s-bg%d", htmlClassPrefix, i))
+		}
+	}
+	if style.bold {
+		classes = append(classes, htmlClassPrefix+"-b")
+	}
+	if style.italic {
+		classes = append(classes, htmlClassPrefix+"-i")
+	}
+	if style.underline {
+		classes = append(classes, htmlClassPrefix+"-u")
+	}
+	if style.strikethrough {
+		classes = append(classes, htmlClassPrefix+"-s")
+	}
+	if style.dim {
+		classes = append(classes, htmlClassPrefix+"-d")
+	}
+	return strings.Join(classes, " ")
+}
+
+// paletteIndex returns the ANSI palette index of a color, or -1
+func (r *Renderer) paletteIndex(c color.RGBA) int {
+	for i, p := range r.palette.ANSI {
+		if p == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// preStyle returns the style of the <pre> element
+func (r *Renderer) preStyle() string {
+	return fmt.Sprintf("background-color:%s;color:%s;font-family:%s;font-size:%spx;line-height:%s;padding:%spx",
+		cssColor(r.palette.Background), hexColor(r.palette.Foreground),
+		r.cssFontFamilies(false),
+		num(r.fontSize/r.scale), num(r.charHeight/r.fontSize), num(r.padding()/r.scale))
+}
+
+// themeCSS returns the stylesheet for class-based HTML output
+func (r *Renderer) themeCSS() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "pre.%s { %s; }\n", htmlClassPrefix, strings.ReplaceAll(r.preStyle(), ";", "; "))
+	fmt.Fprintf(&b, ".%s-b { font-weight: bold; }\n", htmlClassPrefix)
+	fmt.Fprintf(&b, ".%s-i { font-style: italic; }\n", htmlClassPrefix)
+	fmt.Fprintf(&b, ".%s-u { text-decoration: underline; }\n", htmlClassPrefix)
+	fmt.Fprintf(&b, ".%s-s { text-decoration: line-through; }\n", htmlClassPrefix)
+	fmt.Fprintf(&b, ".%s-u.%s-s { text-decoration: underline line-through; }\n", htmlClassPrefix, htmlClassPrefix)
+	fmt.Fprintf(&b, ".%s-d { opacity: 0.5; }\n", htmlClassPrefix)
+	fmt.Fprintf(&b, ".%s-w { %s; }\n", htmlClassPrefix, strings.ReplaceAll(wideCSS, ";", "; "))
+	for i, c := range r.palette.ANSI {
+		fmt.Fprintf(&b, ".%s-fg%d { color: %s; }\n", htmlClassPrefix, i, hexColor(c))
+	}
+	for i, c := range r.palette.ANSI {
+		fmt.Fprintf(&b, ".%s-bg%d { background-color: %s; }\n", htmlClassPrefix, i, hexColor(c))
+	}
+	fmt.Fprintf(&b, "pre.%s a { color: inherit; }\n", htmlClassPrefix)
+	return b.String()
+}
+
+// textDecoration returns the CSS text-decoration of a text style
+func textDecoration(style textStyle) string {
+	switch {
+	case style.underline && style.strikethrough:
+		return "underline line-through"
+	case style.underline:
+		return "underline"
+	case style.strikethrough:
+		return "line-through"
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package renderer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// CellSize returns the size of a cell in logical pixels, which programs
+// drawing images get told through the pty
+func (r *Renderer) CellSize() (width, height int) {
+	return int(math.Round(r.charWidth / r.scale)), int(math.Round(r.charHeight / r.scale))
+}
+
+// imageRect returns where an inline image goes, in pixels
+func (r *Renderer) imageRect(si ScreenImage) image.Rectangle {
+	padding := r.padding()
+	return image.Rect(
+		int(math.Round(padding+si.X*r.charWidth)), int(math.Round(padding+si.Y*r.charHeight)),
+		int(math.Round(padding+(si.X+si.Width)*r.charWidth)), int(math.Round(padding+(si.Y+si.Height)*r.charHeight)))
+}
+
+// screenRect returns the terminal grid without the padding, in pixels
+func (r *Renderer) screenRect(buffer *ScreenBuffer) image.Rectangle {
+	return r.imageRect(ScreenImage{Width: float64(buffer.Width), Height: float64(buffer.Height)})
+}
+
+// drawImages draws the inline images below or above the text, scaled to
+// the cells they cover and clipped to the terminal grid
+func (r *Renderer) drawImages(dst *image.RGBA, buffer *ScreenBuffer, below bool) {
+	screen := dst.SubImage(r.screenRect(buffer)).(*image.RGBA)
+	for _, si := range buffer.Images {
+		if si.Z < 0 != below {
+			continue
+		}
+		src := si.Image.Bounds()
+		rect := r.imageRect(si)
+		if rect.Size() == src.Size() {
+			draw.Draw(screen, rect, si.Image, src.Min, draw.Over)
+		} else {
+			xdraw.CatmullRom.Scale(screen, rect, si.Image, src, draw.Over, nil)
+		}
+	}
+}
+
+// writeSVGImages writes the inline images below or above the text as PNG
+// data URIs, clipped to the terminal grid by the clip path screen
+func (r *Renderer) writeSVGImages(b *strings.Builder, buffer *ScreenBuffer, below bool) {
+	for _, si := range buffer.Images {
+		if si.Z < 0 != below {
+			continue
+		}
+		var data bytes.Buffer
+		if err := png.Encode(&data, si.Image); err != nil {
+			continue
+		}
+		rect := r.imageRect(si)
+		fmt.Fprintf(b, `<image x="%d" y="%d" width="%d" height="%d" preserveAspectRatio="none" clip-path="url(#screen)" href="data:image/png;base64,%s"/>`+"\n",
+			rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy(), base64.StdEncoding.EncodeToString(data.Bytes()))
+	}
+}
@@ -1,19 +1,35 @@
 package renderer
 
 import (
+	"fmt"
+	"image"
 	"image/color"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode"
 
 	"github.com/fogleman/gg"
 	"github.com/rizkyandriawan/eddie/internal/config"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
 )
 
-// ScreenCell represents a single cell with character and colors
+// ScreenCell represents a single cell with character, colors and attributes
 type ScreenCell struct {
-	Char rune
-	FG   color.RGBA
-	BG   color.RGBA
+	Char          rune
+	FG            color.RGBA
+	BG            color.RGBA
+	Bold          bool
+	Italic        bool
+	Underline     bool
+	Strikethrough bool
+	Reverse       bool
+	Dim           bool
+	Link          string // OSC 8 hyperlink target
+	Wide          bool   // Char also takes up the next cell
+	Spacer        bool   // second cell of a wide character
+	Combining     string // marks and joined runes drawn with Char
 }
 
 // ScreenLine represents a line of cells
@@ -23,138 +39,433 @@ type ScreenLine struct {
 
 // ScreenBuffer represents the entire screen state with colors
 type ScreenBuffer struct {
-	Lines  []ScreenLine
-	Width  int
-	Height int
+	Title         string
+	Lines         []ScreenLine
+	Width         int
+	Height        int
+	CursorX       int
+	CursorY       int
+	CursorVisible bool
+	Images        []ScreenImage // drawn in order, later ones on top
+}
+
+// ScreenImage is an image the program drew with sixel or the kitty
+// graphics protocol
+type ScreenImage struct {
+	Image         image.Image
+	X, Y          float64 // top-left corner in cells, Y is negative when scrolled partly off the top
+	Width, Height float64 // size in cells
+	Z             int     // images with a negative Z are drawn below the text
 }
 
 // Renderer renders terminal output to PNG
 type Renderer struct {
 	theme      config.Theme
-	fontPath   string
+	palette    config.Palette
+	fontFamily string
+	fontStyles map[fontStyle]fontRef
+	fonts      map[fontStyle]*sfnt.Font
+	canvas     image.Image // drawn in the margin around the window
+	faces      map[fontStyle]font.Face
+	glyphs     map[glyphKey]*glyphImage
 	charWidth  float64
 	charHeight float64
+	baseline   float64 // distance from the top of a cell to the baseline
+	xHeight    float64
 	fontSize   float64
+	scale      float64 // device pixel ratio
+
+	// Fonts for glyphs missing from the primary font
+	primary          *sfnt.Font
+	primaryCoverage  map[rune]bool
+	fallbacks        []*fallbackFont
+	fallbackQueue    []string
+	fallbackCache    map[rune]*fallbackFont
+	loadedFonts      map[string]bool
+	discoveryStarted bool
+
+	// Color emoji fonts, found when first needed
+	colorFonts    []*colorFont
+	colorQueue    []string
+	colorCache    map[rune]*colorFont
+	colorSearched bool
 }
 
 // NewRenderer creates a new renderer
-func NewRenderer(theme config.Theme) *Renderer {
+func NewRenderer(theme config.Theme) (*Renderer, error) {
 	r := &Renderer{
 		theme: theme,
 	}
+	r.palette, _ = theme.Palette()
+
+	r.scale = theme.Scale
+	if r.scale <= 0 {
+		r.scale = 1
+	}
 
 	// Use larger font size for better resolution
 	r.fontSize = theme.FontSize
 	if r.fontSize < 16 {
 		r.fontSize = 16 // minimum for readability
 	}
+	r.fontSize *= r.scale
 
-	// Try to find a monospace font
-	r.fontPath = findFont()
-
-	// Character dimensions based on font size
-	r.charWidth = r.fontSize * 0.6
-	r.charHeight = r.fontSize * 1.2
-
-	return r
-}
+	// Find the font and its bold/italic variants
+	var err error
+	r.fontStyles, r.fontFamily, err = resolveFont(theme.Font, theme.FontStyle)
+	if err != nil {
+		return nil, fmt.Errorf("theme.font: %w", err)
+	}
+	r.fonts = map[fontStyle]*sfnt.Font{}
+	for style, ref := range r.fontStyles {
+		f, err := loadFont(ref)
+		if err != nil {
+			return nil, fmt.Errorf("theme.font: %w", err)
+		}
+		r.fonts[style] = f
+	}
+	r.primary = r.fonts[styleRegular]
+
+	// Glyphs the font lacks come from the fallbacks, then the system fonts
+	r.primaryCoverage = map[rune]bool{}
+	r.fallbackCache = map[rune]*fallbackFont{}
+	r.loadedFonts = map[string]bool{}
+	for _, ref := range r.fontStyles {
+		r.loadedFonts[ref.path] = true
+	}
+	for _, name := range theme.FontFallbacks {
+		ref, err := resolveFallback(name)
+		if err != nil {
+			return nil, fmt.Errorf("theme.font_fallbacks: %w", err)
+		}
+		r.fallbackQueue = append(r.fallbackQueue, ref.path)
+	}
+	r.colorQueue = append([]string(nil), r.fallbackQueue...)
+	r.colorCache = map[rune]*colorFont{}
 
-// findFont looks for a suitable monospace font
-func findFont() string {
-	// Common monospace font locations
-	paths := []string{
-		// Linux
-		"/usr/share/fonts/truetype/dejavu/DejaVuSansMono.ttf",
-		"/usr/share/fonts/truetype/dejavu/DejaVuSansMono-Bold.ttf",
-		"/usr/share/fonts/TTF/DejaVuSansMono.ttf",
-		"/usr/share/fonts/truetype/liberation/LiberationMono-Regular.ttf",
-		"/usr/share/fonts/truetype/ubuntu/UbuntuMono-R.ttf",
-		"/usr/share/fonts/truetype/jetbrains-mono/JetBrainsMono-Regular.ttf",
-		"/usr/share/fonts/truetype/firacode/FiraCode-Regular.ttf",
-		// macOS
-		"/System/Library/Fonts/Menlo.ttc",
-		"/System/Library/Fonts/Monaco.ttf",
-		"/Library/Fonts/SF-Mono-Regular.otf",
-		// Windows
-		"C:\\Windows\\Fonts\\consola.ttf",
-		"C:\\Windows\\Fonts\\cour.ttf",
-	}
-
-	for _, p := range paths {
-		if _, err := os.Stat(p); err == nil {
-			return p
+	if theme.Window.MarginImage != "" {
+		r.canvas, err = gg.LoadImage(theme.Window.MarginImage)
+		if err != nil {
+			return nil, fmt.Errorf("theme.window.margin_image: %w", err)
 		}
 	}
 
-	return ""
+	r.measure(theme.LineHeight)
+	r.faces = r.loadFaces()
+	r.glyphs = map[glyphKey]*glyphImage{}
+
+	return r, nil
 }
 
 // RenderBuffer renders a ScreenBuffer to a PNG file with colors
 func (r *Renderer) RenderBuffer(buffer *ScreenBuffer, outputPath string) error {
-	// Calculate image dimensions - minimal padding
-	padding := float64(r.theme.Padding)
-	if padding < 6 {
-		padding = 6
+	img := r.RenderImage(buffer)
+
+	// Ensure output directory exists
+	dir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
 	}
 
-	imgWidth := int(float64(buffer.Width)*r.charWidth + padding*2)
-	imgHeight := int(float64(buffer.Height)*r.charHeight + padding*2)
+	return gg.SavePNG(outputPath, img)
+}
+
+// RenderImage renders a ScreenBuffer to an image with colors
+func (r *Renderer) RenderImage(buffer *ScreenBuffer) image.Image {
+	padding := r.padding()
+	imgWidth, imgHeight := r.contentSize(buffer)
 
 	// Create drawing context
 	dc := gg.NewContext(imgWidth, imgHeight)
 
-	// Fill entire background with black (same as terminal default)
-	dc.SetColor(color.RGBA{0, 0, 0, 255})
-	dc.Clear()
+	// Default colors, a transparent background is left clear
+	bgColor := r.palette.Background
+	if bgColor.A > 0 {
+		dc.SetColor(bgColor)
+		dc.Clear()
+	}
+
+	img := dc.Image().(*image.RGBA)
+
+	lineWidth := r.fontSize / 14
+	if lineWidth < r.scale {
+		lineWidth = r.scale
+	}
+
+	// Cursor
+	showCursor, cursorStyle := r.cursor(buffer)
+	cursorColor := r.palette.Cursor
 
-	// Load font
-	if r.fontPath != "" {
-		if err := dc.LoadFontFace(r.fontPath, r.fontSize); err != nil {
-			// Fall back to basic font if loading fails
-			r.fontPath = ""
+	// Backgrounds first, one rectangle per run of cells with the same
+	// color, so wide characters aren't covered by the next cell
+	for row, line := range buffer.Lines {
+		top := padding + float64(row)*r.charHeight
+		var runStart int
+		var runColor color.RGBA
+		for col := 0; col <= len(line.Cells); col++ {
+			var bg color.RGBA
+			if col < len(line.Cells) {
+				isCursor := showCursor && row == buffer.CursorY && col == buffer.CursorX
+				_, bg = r.cellColors(line.Cells[col], isCursor, cursorStyle)
+				// Only draw backgrounds that aren't the default
+				if bg == bgColor && !isCursor {
+					bg = color.RGBA{}
+				}
+			}
+			if col < len(line.Cells) && bg == runColor {
+				continue
+			}
+			if runColor.A > 0 {
+				dc.SetColor(runColor)
+				dc.DrawRectangle(padding+float64(runStart)*r.charWidth, top, float64(col-runStart)*r.charWidth, r.charHeight)
+				dc.Fill()
+			}
+			runStart, runColor = col, bg
 		}
 	}
 
-	// Default colors
-	bgColor := parseHexColor(r.theme.Background)
-	defaultBG := color.RGBA{0, 0, 0, 255} // Terminal default is black
+	r.drawImages(img, buffer, true)
 
 	// Render each cell
 	for row, line := range buffer.Lines {
-		y := padding + float64(row)*r.charHeight + r.charHeight*0.85 // baseline offset
+		top := padding + float64(row)*r.charHeight
+		y := top + r.baseline
 
 		for col, cell := range line.Cells {
 			x := padding + float64(col)*r.charWidth
 
-			// Draw background if not default black
-			if cell.BG != defaultBG && cell.BG.A > 0 && cell.BG != bgColor {
-				dc.SetColor(cell.BG)
-				dc.DrawRectangle(x, padding+float64(row)*r.charHeight, r.charWidth, r.charHeight)
-				dc.Fill()
+			isCursor := showCursor && row == buffer.CursorY && col == buffer.CursorX
+			fg, _ := r.cellColors(cell, isCursor, cursorStyle)
+
+			textColor := color.NRGBA{fg.R, fg.G, fg.B, 255}
+			if cell.Dim {
+				textColor.A = 128
 			}
 
 			// Draw character
-			if cell.Char != ' ' && cell.Char != 0 {
-				dc.SetColor(cell.FG)
-				dc.DrawString(string(cell.Char), x, y)
+			if isBoxChar(cell.Char) || cell.Char != ' ' && cell.Char != 0 {
+				r.cachedGlyph(cell, textColor).draw(img, x, top)
+			}
+
+			// Draw decoration lines
+			if r.underlined(cell) {
+				dc.SetColor(textColor)
+				dc.DrawRectangle(x, y+lineWidth, r.charWidth, lineWidth)
+				dc.Fill()
+			}
+			if cell.Strikethrough {
+				dc.SetColor(textColor)
+				dc.DrawRectangle(x, y-r.xHeight/2-lineWidth/2, r.charWidth, lineWidth)
+				dc.Fill()
+			}
+
+			if isCursor {
+				r.drawCursor(dc, cursorStyle, cursorColor, x, top)
 			}
 		}
 	}
 
-	// Ensure output directory exists
-	dir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	r.drawImages(img, buffer, false)
+
+	return r.decorate(img, bgColor, buffer.Title, r.faces[styleRegular])
+}
+
+// Size returns the size in pixels of the raster image of a ScreenBuffer
+func (r *Renderer) Size(buffer *ScreenBuffer) (width, height int) {
+	return r.decoratedSize(r.contentSize(buffer))
+}
+
+// Scale returns the device pixel ratio of raster images
+func (r *Renderer) Scale() float64 {
+	return r.scale
+}
+
+// contentSize returns the size of the terminal grid with its padding
+func (r *Renderer) contentSize(buffer *ScreenBuffer) (width, height int) {
+	padding := r.padding()
+	width = int(float64(buffer.Width)*r.charWidth + padding*2)
+	height = int(float64(buffer.Height)*r.charHeight + padding*2)
+	return width, height
+}
+
+// padding returns the space between the terminal grid and the image border
+func (r *Renderer) padding() float64 {
+	// Minimal padding
+	padding := float64(r.theme.Padding)
+	if padding < 6 {
+		padding = 6
+	}
+	return padding * r.scale
+}
+
+// cursor returns whether the cursor is drawn and its style
+func (r *Renderer) cursor(buffer *ScreenBuffer) (bool, string) {
+	show := buffer.CursorVisible
+	if r.theme.Cursor.Visible != nil {
+		show = *r.theme.Cursor.Visible
+	}
+	return show, strings.ToLower(r.theme.Cursor.Style)
+}
+
+// cellColors returns the foreground and background of a cell after applying
+// reverse video and a block cursor
+func (r *Renderer) cellColors(cell ScreenCell, isCursor bool, cursorStyle string) (color.RGBA, color.RGBA) {
+	fg, bg := cell.FG, cell.BG
+	if cell.Reverse {
+		fg, bg = bg, fg
 	}
 
-	return dc.SavePNG(outputPath)
+	// A block cursor inverts the cell under it
+	if isCursor && (cursorStyle == "" || cursorStyle == "block") {
+		fg, bg = bg, r.palette.Cursor
+	}
+	return fg, bg
+}
+
+// drawCursor draws bar and underline cursors over a cell
+// (block cursors are drawn as part of the cell)
+func (r *Renderer) drawCursor(dc *gg.Context, style string, c color.RGBA, x, top float64) {
+	thickness := r.cursorThickness()
+
+	dc.SetColor(c)
+	switch style {
+	case "bar", "beam":
+		dc.DrawRectangle(x, top, thickness, r.charHeight)
+	case "underline":
+		dc.DrawRectangle(x, top+r.charHeight-thickness, r.charWidth, thickness)
+	default:
+		return
+	}
+	dc.Fill()
+}
+
+// cursorThickness returns the width of bar cursors and height of underline cursors
+func (r *Renderer) cursorThickness() float64 {
+	thickness := r.fontSize / 8
+	if thickness < 2*r.scale {
+		thickness = 2 * r.scale
+	}
+	return thickness
+}
+
+// drawStyledChar draws a character in a color with the face matching its
+// attributes, synthesizing bold and italic when the font has no such variant
+func (r *Renderer) drawStyledChar(dc *gg.Context, cell ScreenCell, c color.Color, x, y float64) {
+	dc.SetColor(c)
+	if wantsColor(cell) {
+		if cf := r.colorFontFor(cell.Char); cf != nil && r.drawColorGlyph(dc, cf, cell, c, x, y-r.baseline, y) {
+			return
+		}
+	}
+	if !r.primaryHasGlyph(cell.Char) {
+		if fb := r.fallbackFor(cell.Char); fb != nil {
+			r.drawFallbackChar(dc, fb, cell, x, y)
+			return
+		}
+	}
+
+	style := styleFor(cell.Bold, cell.Italic)
+	face, ok := r.faces[style]
+	fakeBold := false
+	fakeItalic := false
+	if !ok {
+		// Fall back to the closest available face
+		face, ok = r.faces[styleFor(cell.Bold, false)]
+		fakeItalic = cell.Italic
+		if !ok {
+			face = r.faces[styleRegular]
+			fakeBold = cell.Bold
+		}
+	}
+	if face != nil {
+		dc.SetFontFace(face)
+	}
+
+	if face == nil {
+		dc.DrawString(string(cell.Char), x, y)
+		return
+	}
+
+	marks := clusterMarks(cell, r.primary)
+	dc.Push()
+	defer dc.Pop()
+	if fakeItalic {
+		dc.ShearAbout(-0.2, 0, x, y)
+	}
+	if cell.Wide {
+		x = r.fitGlyph(dc, face, cell, x, y)
+	}
+	drawCluster(dc, face, cell.Char, marks, x, y)
+	if fakeBold {
+		drawCluster(dc, face, cell.Char, marks, x+r.scale, y)
+	}
+}
+
+// underlined reports whether a cell is drawn underlined, including
+// hyperlinks rendered as links when theme.link_underline is set
+func (r *Renderer) underlined(cell ScreenCell) bool {
+	return cell.Underline || safeLink(cell.Link) != "" && r.theme.LinkUnderline
+}
+
+// cellSpan returns the width of the cells a character takes up
+func (r *Renderer) cellSpan(cell ScreenCell) float64 {
+	if cell.Wide {
+		return 2 * r.charWidth
+	}
+	return r.charWidth
+}
+
+// fitGlyph centers a glyph narrower than its cells and squeezes a wider
+// one into them, and returns the x to draw it at
+func (r *Renderer) fitGlyph(dc *gg.Context, face font.Face, cell ScreenCell, x, y float64) float64 {
+	span := r.cellSpan(cell)
+	if advance, ok := face.GlyphAdvance(cell.Char); ok {
+		width := float64(advance) / 64
+		if width > span {
+			dc.ScaleAbout(span/width, 1, x, y)
+		} else {
+			x += (span - width) / 2
+		}
+	}
+	return x
+}
+
+// clusterMarks returns the combining marks of a cell the font can draw.
+// Emoji sequences need text shaping, only their first emoji is drawn.
+func clusterMarks(cell ScreenCell, f *sfnt.Font) []rune {
+	var marks []rune
+	for _, mark := range cell.Combining {
+		if mark == '\u200d' { // zero-width joiner
+			break
+		}
+		if unicode.In(mark, unicode.Mn, unicode.Me) && f != nil && hasGlyph(f, mark) {
+			marks = append(marks, mark)
+		}
+	}
+	return marks
+}
+
+// drawCluster draws a character with its combining marks. Monospace fonts
+// give marks the advance of a cell and expect them over the character,
+// other fonts give them none and expect them after it.
+func drawCluster(dc *gg.Context, face font.Face, ch rune, marks []rune, x, y float64) {
+	dc.DrawString(string(ch), x, y)
+	for _, mark := range marks {
+		markX := x
+		if advance, ok := face.GlyphAdvance(mark); ok && advance == 0 {
+			if base, ok := face.GlyphAdvance(ch); ok {
+				markX += float64(base) / 64
+			}
+		}
+		dc.DrawString(string(mark), markX, y)
+	}
 }
 
 // Render renders plain text terminal output to a PNG file (legacy method)
 func (r *Renderer) Render(output string, width, height int, outputPath string) error {
 	// Convert plain text to ScreenBuffer (all default colors)
 	fgColor := parseHexColor(r.theme.Foreground)
-	bgColor := parseHexColor(r.theme.Background)
+	bgColor := r.palette.Background
 
 	buffer := &ScreenBuffer{
 		Width:  width,
@@ -0,0 +1,104 @@
+package renderer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/rizkyandriawan/eddie/internal/config"
+)
+
+// benchBuffer returns a screen full of colored text, like the output of a
+// build or a directory listing
+func benchBuffer(palette config.Palette, width, height int) *ScreenBuffer {
+	buffer := &ScreenBuffer{Width: width, Height: height, Lines: make([]ScreenLine, height)}
+	words := []string{"drwxr-xr-x", "main.go", "go", "build", "./...", "ok", "FAIL", "github.com/rizkyandriawan/eddie", "0.012s", "│", "─"}
+	for row := range buffer.Lines {
+		cells := make([]ScreenCell, width)
+		col := 0
+		for i := row; col < width; i++ {
+			word := words[i%len(words)]
+			cell := ScreenCell{FG: palette.ANSI[i%8+8], BG: palette.Background, Bold: i%5 == 0}
+			if i%13 == 0 {
+				cell.BG = palette.ANSI[4]
+			}
+			for _, ch := range word + " " {
+				if col == width {
+					break
+				}
+				cell.Char = ch
+				cells[col] = cell
+				col++
+			}
+		}
+		buffer.Lines[row].Cells = cells
+	}
+	return buffer
+}
+
+// renderUncached renders a buffer the way RenderImage did before the glyph
+// cache: every cell fills its own background and draws its character with
+// gg. It is the reference the benchmark compares against.
+func renderUncached(r *Renderer, buffer *ScreenBuffer) image.Image {
+	padding := r.padding()
+	imgWidth, imgHeight := r.contentSize(buffer)
+	dc := gg.NewContext(imgWidth, imgHeight)
+	dc.SetColor(r.palette.Background)
+	dc.Clear()
+
+	showCursor, cursorStyle := r.cursor(buffer)
+	for row, line := range buffer.Lines {
+		top := padding + float64(row)*r.charHeight
+		for col, cell := range line.Cells {
+			x := padding + float64(col)*r.charWidth
+			isCursor := showCursor && row == buffer.CursorY && col == buffer.CursorX
+			fg, bg := r.cellColors(cell, isCursor, cursorStyle)
+			if bg != r.palette.Background {
+				dc.SetColor(bg)
+				dc.DrawRectangle(x, top, r.charWidth, r.charHeight)
+				dc.Fill()
+			}
+
+			textColor := color.NRGBA{fg.R, fg.G, fg.B, 255}
+			switch {
+			case isBoxChar(cell.Char):
+				r.drawBoxChar(ggPen{dc: dc, color: textColor}, cell.Char, x, top)
+			case cell.Char != ' ' && cell.Char != 0:
+				r.drawStyledChar(dc, cell, textColor, x, top+r.baseline)
+			}
+		}
+	}
+	return r.decorate(dc.Image().(*image.RGBA), r.palette.Background, buffer.Title, r.faces[styleRegular])
+}
+
+// BenchmarkRenderImage renders frames with one renderer, as when capturing
+// the frames of an animation. The uncached runs draw every cell on its own,
+// as before the glyph cache and batched fills.
+func BenchmarkRenderImage(b *testing.B) {
+	theme := config.Theme{FontSize: 14, LineHeight: 1, Scale: 1, Padding: 20}
+	for _, size := range []struct{ width, height int }{{80, 24}, {200, 60}} {
+		for _, uncached := range []bool{false, true} {
+			name := fmt.Sprintf("%dx%d/cached", size.width, size.height)
+			if uncached {
+				name = fmt.Sprintf("%dx%d/uncached", size.width, size.height)
+			}
+			b.Run(name, func(b *testing.B) {
+				r, err := NewRenderer(theme)
+				if err != nil {
+					b.Fatal(err)
+				}
+				buffer := benchBuffer(r.palette, size.width, size.height)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if uncached {
+						renderUncached(r, buffer)
+					} else {
+						r.RenderImage(buffer)
+					}
+				}
+			})
+		}
+	}
+}
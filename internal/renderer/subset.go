@@ -0,0 +1,255 @@
+package renderer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// Composite glyph flags
+const (
+	compositeArgsAreWords = 0x0001
+	compositeHaveScale    = 0x0008
+	compositeMoreGlyphs   = 0x0020
+	compositeHaveXYScale  = 0x0040
+	compositeHave2x2      = 0x0080
+)
+
+var errNotTrueType = errors.New("not a TrueType font")
+
+// sfntTable is a table of an sfnt font file
+type sfntTable struct {
+	tag  string
+	data []byte
+}
+
+// isOpenTypeCFF reports whether the font data is an OpenType font with CFF outlines
+func isOpenTypeCFF(data []byte) bool {
+	return len(data) >= 4 && string(data[:4]) == "OTTO"
+}
+
+// subsetTrueType strips the outlines of all glyphs that are not needed to draw
+// the given runes. Glyph ids stay the same, so the cmap and metrics tables can
+// be kept as they are while the glyf table, which makes up most of the file,
+// only contains the used glyphs.
+func subsetTrueType(data []byte, runes []rune) ([]byte, error) {
+	if len(data) < 12 || binary.BigEndian.Uint32(data) != 0x00010000 && string(data[:4]) != "true" {
+		return nil, errNotTrueType
+	}
+
+	tables, err := readSfntTables(data)
+	if err != nil {
+		return nil, err
+	}
+	head, loca, glyf := tables["head"], tables["loca"], tables["glyf"]
+	maxp := tables["maxp"]
+	if len(head) < 54 || loca == nil || glyf == nil || len(maxp) < 6 {
+		return nil, errNotTrueType
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+	offsets := make([]uint32, numGlyphs+1)
+	for i := range offsets {
+		if longLoca {
+			if 4*i+4 > len(loca) {
+				return nil, errNotTrueType
+			}
+			offsets[i] = binary.BigEndian.Uint32(loca[4*i:])
+		} else {
+			if 2*i+2 > len(loca) {
+				return nil, errNotTrueType
+			}
+			offsets[i] = uint32(binary.BigEndian.Uint16(loca[2*i:])) * 2
+		}
+	}
+	glyph := func(id int) []byte {
+		start, end := offsets[id], offsets[id+1]
+		if start >= end || int(end) > len(glyf) {
+			return nil
+		}
+		return glyf[start:end]
+	}
+
+	// Collect the glyphs used by the runes, including composite components
+	f, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf sfnt.Buffer
+	keep := map[int]bool{0: true}
+	var queue []int
+	for _, r := range runes {
+		id, err := f.GlyphIndex(&buf, r)
+		if err != nil || id == 0 || int(id) >= numGlyphs {
+			continue
+		}
+		if !keep[int(id)] {
+			keep[int(id)] = true
+			queue = append(queue, int(id))
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, component := range compositeComponents(glyph(id)) {
+			if component < numGlyphs && !keep[component] {
+				keep[component] = true
+				queue = append(queue, component)
+			}
+		}
+	}
+
+	// Rebuild glyf and loca (long format)
+	var newGlyf bytes.Buffer
+	newLoca := make([]byte, 4*(numGlyphs+1))
+	for id := 0; id < numGlyphs; id++ {
+		binary.BigEndian.PutUint32(newLoca[4*id:], uint32(newGlyf.Len()))
+		if keep[id] {
+			newGlyf.Write(glyph(id))
+			for newGlyf.Len()%4 != 0 {
+				newGlyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*numGlyphs:], uint32(newGlyf.Len()))
+
+	newHead := append([]byte(nil), head...)
+	binary.BigEndian.PutUint16(newHead[50:], 1)
+
+	tables["glyf"] = newGlyf.Bytes()
+	tables["loca"] = newLoca
+	tables["head"] = newHead
+	delete(tables, "DSIG") // the signature no longer matches
+
+	return writeSfnt(binary.BigEndian.Uint32(data), tables), nil
+}
+
+// compositeComponents returns the glyph ids referenced by a composite glyph
+func compositeComponents(g []byte) []int {
+	if len(g) < 10 || int16(binary.BigEndian.Uint16(g)) >= 0 {
+		return nil
+	}
+
+	var ids []int
+	p := 10
+	for p+4 <= len(g) {
+		flags := binary.BigEndian.Uint16(g[p:])
+		ids = append(ids, int(binary.BigEndian.Uint16(g[p+2:])))
+		p += 4
+		if flags&compositeArgsAreWords != 0 {
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&compositeHaveScale != 0:
+			p += 2
+		case flags&compositeHaveXYScale != 0:
+			p += 4
+		case flags&compositeHave2x2 != 0:
+			p += 8
+		}
+		if flags&compositeMoreGlyphs == 0 {
+			break
+		}
+	}
+	return ids
+}
+
+// readSfntTables reads the table directory of an sfnt font file
+func readSfntTables(data []byte) (map[string][]byte, error) {
+	return readSfntTablesAt(data, 0)
+}
+
+// readSfntTablesAt reads the table directory at an offset of a font file,
+// one of the fonts of a collection
+func readSfntTablesAt(data []byte, dir int) (map[string][]byte, error) {
+	if dir < 0 || dir+12 > len(data) {
+		return nil, errNotTrueType
+	}
+	numTables := int(binary.BigEndian.Uint16(data[dir+4:]))
+	if dir+12+16*numTables > len(data) {
+		return nil, errNotTrueType
+	}
+
+	tables := map[string][]byte{}
+	for i := 0; i < numTables; i++ {
+		rec := data[dir+12+16*i:]
+		tag := string(rec[:4])
+		offset := binary.BigEndian.Uint32(rec[8:])
+		length := binary.BigEndian.Uint32(rec[12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, errNotTrueType
+		}
+		tables[tag] = data[offset : offset+length]
+	}
+	return tables, nil
+}
+
+// writeSfnt writes an sfnt font file with the given tables
+func writeSfnt(version uint32, tableMap map[string][]byte) []byte {
+	var tables []sfntTable
+	for tag, data := range tableMap {
+		tables = append(tables, sfntTable{tag, data})
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].tag < tables[j].tag })
+
+	numTables := len(tables)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= numTables {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+
+	header := make([]byte, 12+16*numTables)
+	binary.BigEndian.PutUint32(header, version)
+	binary.BigEndian.PutUint16(header[4:], uint16(numTables))
+	binary.BigEndian.PutUint16(header[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(header[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(header[10:], uint16(numTables*16-searchRange))
+
+	var body bytes.Buffer
+	headOffset := -1
+	for i, t := range tables {
+		offset := len(header) + body.Len()
+		data := t.data
+		if t.tag == "head" {
+			// checkSumAdjustment must be zero while computing checksums
+			data = append([]byte(nil), data...)
+			binary.BigEndian.PutUint32(data[8:], 0)
+			headOffset = offset
+		}
+
+		rec := header[12+16*i:]
+		copy(rec, t.tag)
+		binary.BigEndian.PutUint32(rec[4:], sfntChecksum(data))
+		binary.BigEndian.PutUint32(rec[8:], uint32(offset))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(data)))
+
+		body.Write(data)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+
+	font := append(header, body.Bytes()...)
+	if headOffset != -1 {
+		binary.BigEndian.PutUint32(font[headOffset+8:], 0xB1B0AFBA-sfntChecksum(font))
+	}
+	return font
+}
+
+// sfntChecksum computes the checksum of a table (sum of big-endian uint32s)
+func sfntChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,349 @@
+package renderer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// svgFontFamily is the family name of the font embedded in SVG output
+const svgFontFamily = "EddieMono"
+
+// textStyle is the part of a cell that decides how its text is drawn
+type textStyle struct {
+	fg            color.RGBA
+	bold          bool
+	italic        bool
+	underline     bool
+	strikethrough bool
+	dim           bool
+}
+
+// RenderSVG renders a ScreenBuffer to an SVG file with selectable text
+func (r *Renderer) RenderSVG(buffer *ScreenBuffer, outputPath string, embedFont bool) error {
+	padding := r.padding()
+	width := float64(buffer.Width)*r.charWidth + padding*2
+	height := float64(buffer.Height)*r.charHeight + padding*2
+
+	bgColor := r.palette.Background
+	showCursor, cursorStyle := r.cursor(buffer)
+
+	// The drawing uses raster pixels, the viewBox maps them back to the logical size
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s">`+"\n",
+		num(width/r.scale), num(height/r.scale), num(width), num(height))
+
+	// Styles
+	b.WriteString("<style>\n")
+	if embedFont {
+		b.WriteString(r.svgFontFaces(buffer))
+	}
+	fmt.Fprintf(&b, "text { font-family: %s; font-size: %spx; white-space: pre; }\n", r.cssFontFamilies(embedFont), num(r.fontSize))
+	b.WriteString(".b { font-weight: bold; }\n.i { font-style: italic; }\n")
+	b.WriteString(".u { text-decoration: underline; }\n.s { text-decoration: line-through; }\n.us { text-decoration: underline line-through; }\n")
+	b.WriteString(".d { opacity: 0.5; }\n")
+	b.WriteString("</style>\n")
+
+	// A transparent background is left out
+	if bgColor.A > 0 {
+		fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", hexColor(bgColor))
+	}
+
+	// Inline images are clipped to the terminal grid
+	if len(buffer.Images) > 0 {
+		screen := r.screenRect(buffer)
+		fmt.Fprintf(&b, `<clipPath id="screen"><rect x="%d" y="%d" width="%d" height="%d"/></clipPath>`+"\n",
+			screen.Min.X, screen.Min.Y, screen.Dx(), screen.Dy())
+	}
+
+	// Backgrounds, one rect per run of cells with the same color
+	for row, line := range buffer.Lines {
+		top := padding + float64(row)*r.charHeight
+		start := -1
+		var runColor color.RGBA
+		flush := func(end int) {
+			if start == -1 {
+				return
+			}
+			fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+				num(padding+float64(start)*r.charWidth), num(top),
+				num(float64(end-start)*r.charWidth), num(r.charHeight), hexColor(runColor))
+			start = -1
+		}
+
+		for col, cell := range line.Cells {
+			isCursor := showCursor && row == buffer.CursorY && col == buffer.CursorX
+			_, bg := r.cellColors(cell, isCursor, cursorStyle)
+			visible := bg.A > 0 && (bg != bgColor || isCursor)
+			if start != -1 && (!visible || bg != runColor) {
+				flush(col)
+			}
+			if visible && start == -1 {
+				start = col
+				runColor = bg
+			}
+		}
+		flush(len(line.Cells))
+	}
+
+	r.writeSVGImages(&b, buffer, true)
+
+	// Text, one element per run of cells with the same style and link
+	for row, line := range buffer.Lines {
+		y := padding + float64(row)*r.charHeight + r.baseline
+		col := 0
+		for col < len(line.Cells) {
+			style := r.svgTextStyle(buffer, line.Cells[col], row, col, showCursor, cursorStyle)
+			link := safeLink(line.Cells[col].Link)
+			end := col + 1
+			for end < len(line.Cells) && safeLink(line.Cells[end].Link) == link &&
+				r.svgTextStyle(buffer, line.Cells[end], row, end, showCursor, cursorStyle) == style {
+				end++
+			}
+			if link != "" {
+				fmt.Fprintf(&b, `<a href="%s">`, escapeXML(link))
+			}
+			r.writeSVGText(&b, line.Cells[col:end], style, padding+float64(col)*r.charWidth, y)
+			if link != "" {
+				b.WriteString("</a>\n")
+			}
+			col = end
+		}
+	}
+
+	// Box drawing, block and braille characters
+	for row, line := range buffer.Lines {
+		top := padding + float64(row)*r.charHeight
+		for col, cell := range line.Cells {
+			if !isBoxChar(cell.Char) {
+				continue
+			}
+			style := r.svgTextStyle(buffer, cell, row, col, showCursor, cursorStyle)
+			r.drawBoxChar(svgPen{b: &b, color: style.fg, dim: style.dim}, cell.Char, padding+float64(col)*r.charWidth, top)
+		}
+	}
+
+	r.writeSVGImages(&b, buffer, false)
+
+	// Bar and underline cursors
+	if showCursor && buffer.CursorY < len(buffer.Lines) {
+		x := padding + float64(buffer.CursorX)*r.charWidth
+		top := padding + float64(buffer.CursorY)*r.charHeight
+		thickness := r.cursorThickness()
+		switch cursorStyle {
+		case "bar", "beam":
+			fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+				num(x), num(top), num(thickness), num(r.charHeight), hexColor(r.palette.Cursor))
+		case "underline":
+			fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+				num(x), num(top+r.charHeight-thickness), num(r.charWidth), num(thickness), hexColor(r.palette.Cursor))
+		}
+	}
+
+	b.WriteString("</svg>\n")
+
+	// Ensure output directory exists
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, []byte(b.String()), 0644)
+}
+
+// svgTextStyle returns the text style of a cell
+func (r *Renderer) svgTextStyle(buffer *ScreenBuffer, cell ScreenCell, row, col int, showCursor bool, cursorStyle string) textStyle {
+	isCursor := showCursor && row == buffer.CursorY && col == buffer.CursorX
+	fg, _ := r.cellColors(cell, isCursor, cursorStyle)
+	return textStyle{
+		fg:            fg,
+		bold:          cell.Bold,
+		italic:        cell.Italic,
+		underline:     r.underlined(cell),
+		strikethrough: cell.Strikethrough,
+		dim:           cell.Dim,
+	}
+}
+
+// writeSVGText writes a run of cells as a <text> element
+func (r *Renderer) writeSVGText(b *strings.Builder, cells []ScreenCell, style textStyle, x, y float64) {
+	// Text of each character and the number of cells it takes up
+	type svgChar struct {
+		text  string
+		cells int
+	}
+	chars := make([]svgChar, 0, len(cells))
+	for i, cell := range cells {
+		switch {
+		case cell.Spacer && i > 0:
+			continue // part of the wide character before it
+		case isBoxChar(cell.Char):
+			chars = append(chars, svgChar{" ", 1}) // drawn as shapes
+		case cell.Wide:
+			chars = append(chars, svgChar{string(svgRune(cell.Char)) + cell.Combining, 2})
+		default:
+			chars = append(chars, svgChar{string(svgRune(cell.Char)) + cell.Combining, 1})
+		}
+	}
+
+	// Spaces without decoration don't need to be drawn
+	if !style.underline && !style.strikethrough {
+		start := 0
+		for start < len(chars) && chars[start].text == " " {
+			start++
+		}
+		end := len(chars)
+		for end > start && chars[end-1].text == " " {
+			end--
+		}
+		if start == end {
+			return
+		}
+		x += float64(start) * r.charWidth
+		chars = chars[start:end]
+	}
+
+	var text strings.Builder
+	length := 0
+	for _, c := range chars {
+		text.WriteString(c.text)
+		length += c.cells
+	}
+
+	var classes []string
+	if style.bold {
+		classes = append(classes, "b")
+	}
+	if style.italic {
+		classes = append(classes, "i")
+	}
+	switch {
+	case style.underline && style.strikethrough:
+		classes = append(classes, "us")
+	case style.underline:
+		classes = append(classes, "u")
+	case style.strikethrough:
+		classes = append(classes, "s")
+	}
+	if style.dim {
+		classes = append(classes, "d")
+	}
+
+	fmt.Fprintf(b, `<text x="%s" y="%s" fill="%s" textLength="%s" lengthAdjust="spacingAndGlyphs"`,
+		num(x), num(y), hexColor(style.fg), num(float64(length)*r.charWidth))
+	if len(classes) > 0 {
+		fmt.Fprintf(b, ` class="%s"`, strings.Join(classes, " "))
+	}
+	b.WriteString(">")
+	b.WriteString(escapeXML(text.String()))
+	b.WriteString("</text>\n")
+}
+
+// cssFontFamilies returns the CSS font-family list for text output
+func (r *Renderer) cssFontFamilies(embedFont bool) string {
+	var families []string
+	if embedFont {
+		families = append(families, svgFontFamily)
+	}
+	if r.fontFamily != "" {
+		families = append(families, fmt.Sprintf("'%s'", strings.ReplaceAll(r.fontFamily, "'", "")))
+	}
+	return strings.Join(append(families, "monospace"), ", ")
+}
+
+// svgFontFaces returns @font-face rules embedding the subset of each font
+// style used by the buffer
+func (r *Renderer) svgFontFaces(buffer *ScreenBuffer) string {
+	used := map[fontStyle]map[rune]bool{}
+	for _, line := range buffer.Lines {
+		for _, cell := range line.Cells {
+			style := styleFor(cell.Bold, cell.Italic)
+			if _, ok := r.fontStyles[style]; !ok {
+				style = styleRegular
+			}
+			if used[style] == nil {
+				used[style] = map[rune]bool{}
+			}
+			used[style][svgRune(cell.Char)] = true
+			for _, mark := range cell.Combining {
+				used[style][mark] = true
+			}
+		}
+	}
+
+	// Styles in a fixed order so the output is the same on every run
+	var b strings.Builder
+	for style := styleRegular; style <= styleBoldItalic; style++ {
+		runeSet := used[style]
+		ref, ok := r.fontStyles[style]
+		if runeSet == nil || !ok {
+			continue
+		}
+		data, err := readFontData(ref)
+		if err != nil {
+			continue
+		}
+
+		runes := make([]rune, 0, len(runeSet))
+		for ch := range runeSet {
+			runes = append(runes, ch)
+		}
+		sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+		mime, format := "font/ttf", "truetype"
+		if subset, err := subsetTrueType(data, runes); err == nil {
+			data = subset
+		} else if isOpenTypeCFF(data) {
+			mime, format = "font/otf", "opentype"
+		} else {
+			// Font collections and other formats can't be embedded
+			continue
+		}
+
+		weight, fontStyle := "normal", "normal"
+		if style == styleBold || style == styleBoldItalic {
+			weight = "bold"
+		}
+		if style == styleItalic || style == styleBoldItalic {
+			fontStyle = "italic"
+		}
+		fmt.Fprintf(&b, "@font-face { font-family: %s; font-weight: %s; font-style: %s; src: url(data:%s;base64,%s) format('%s'); }\n",
+			svgFontFamily, weight, fontStyle, mime, base64.StdEncoding.EncodeToString(data), format)
+	}
+	return b.String()
+}
+
+// svgRune replaces characters that are not allowed in XML
+func svgRune(ch rune) rune {
+	if ch < 0x20 || ch == 0x7f {
+		return ' '
+	}
+	return ch
+}
+
+// escapeXML escapes text for use in XML content and attributes
+func escapeXML(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&#39;").Replace(s)
+}
+
+// hexColor formats a color as "#rrggbb"
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// cssColor formats a color for CSS, keeping transparency
+func cssColor(c color.RGBA) string {
+	if c.A == 0 {
+		return "transparent"
+	}
+	return hexColor(c)
+}
+
+// num formats a coordinate with at most two decimals
+func num(v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
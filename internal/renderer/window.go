@@ -0,0 +1,306 @@
+package renderer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/rizkyandriawan/eddie/internal/config"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+)
+
+// Traffic light colors for the macOS window style
+var (
+	macosClose    = color.RGBA{255, 95, 86, 255}
+	macosMinimize = color.RGBA{255, 189, 46, 255}
+	macosMaximize = color.RGBA{39, 201, 63, 255}
+)
+
+// titleBarHeight returns the height of the window title bar for the configured style
+func (r *Renderer) titleBarHeight() float64 {
+	switch strings.ToLower(r.theme.Window.Style) {
+	case "macos", "windows":
+		return math.Round(r.fontSize * 2)
+	case "minimal":
+		return math.Round(r.fontSize * 1.6)
+	default:
+		return 0
+	}
+}
+
+// margin returns the space around the window
+func (r *Renderer) margin() float64 {
+	return float64(r.theme.Window.Margin) * r.scale
+}
+
+// decoratedSize returns the image size of content wrapped in window chrome and margin
+func (r *Renderer) decoratedSize(width, height int) (int, int) {
+	style := strings.ToLower(r.theme.Window.Style)
+	margin := r.margin()
+	if (style == "" || style == "none") && margin == 0 {
+		return width, height
+	}
+	return int(float64(width) + margin*2), int(float64(height) + r.titleBarHeight() + margin*2)
+}
+
+// decorate wraps the rendered terminal in window chrome and the outer margin
+func (r *Renderer) decorate(content image.Image, bgColor color.RGBA, title string, face font.Face) image.Image {
+	style := strings.ToLower(r.theme.Window.Style)
+	margin := r.margin()
+	if (style == "" || style == "none") && margin == 0 {
+		return content
+	}
+
+	if r.theme.Window.Title != "" {
+		title = r.theme.Window.Title
+	}
+
+	bounds := content.Bounds()
+	barHeight := r.titleBarHeight()
+	winWidth := float64(bounds.Dx())
+	winHeight := float64(bounds.Dy()) + barHeight
+	radius := r.theme.Window.Radius * r.scale
+	if style == "" || style == "none" {
+		radius = 0
+	}
+
+	imgWidth, imgHeight := r.decoratedSize(bounds.Dx(), bounds.Dy())
+	dc := gg.NewContext(imgWidth, imgHeight)
+
+	// Outer margin
+	r.drawMargin(dc, imgWidth, imgHeight)
+
+	// Drop shadow
+	if r.theme.Window.Shadow && margin > 0 {
+		shadow := windowShadow(imgWidth, imgHeight, margin, winWidth, winHeight, radius, bgColor.A == 0)
+		draw.DrawMask(dc.Image().(*image.RGBA), dc.Image().Bounds(),
+			image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, shadow, image.Point{}, draw.Over)
+	}
+
+	// Window body, clipped to the rounded corners
+	drawRoundedRect(dc, margin, margin, winWidth, winHeight, radius)
+	dc.Clip()
+	dc.SetColor(bgColor)
+	dc.DrawRectangle(margin, margin, winWidth, winHeight)
+	dc.Fill()
+	dc.DrawImage(content, int(margin), int(margin+barHeight))
+
+	if barHeight > 0 {
+		r.drawTitleBar(dc, style, title, face, bgColor, margin, winWidth, barHeight)
+	}
+	dc.ResetClip()
+
+	return dc.Image()
+}
+
+// drawMargin fills the area around the window with an image, a gradient or
+// a solid color. Without any the margin is transparent.
+func (r *Renderer) drawMargin(dc *gg.Context, width, height int) {
+	w := r.theme.Window
+	switch {
+	case r.canvas != nil:
+		drawCover(dc.Image().(*image.RGBA), r.canvas)
+	case len(w.MarginGradient) > 1:
+		gradient := gg.NewLinearGradient(0, 0, float64(width), float64(height))
+		for i, stop := range w.MarginGradient {
+			c, _ := config.ParseColor(stop) // checked by config.Load
+			gradient.AddColorStop(float64(i)/float64(len(w.MarginGradient)-1), c)
+		}
+		dc.SetFillStyle(gradient)
+		dc.DrawRectangle(0, 0, float64(width), float64(height))
+		dc.Fill()
+	case w.MarginColor != "":
+		c, _ := config.ParseColor(w.MarginColor)
+		dc.SetColor(c)
+		dc.Clear()
+	}
+}
+
+// drawTitleBar draws the title bar with its buttons and title
+func (r *Renderer) drawTitleBar(dc *gg.Context, style, title string, face font.Face, bg color.RGBA, margin, width, height float64) {
+	barColor := titleBarColor(bg)
+	dc.SetColor(barColor)
+	dc.DrawRectangle(margin, margin, width, height)
+	dc.Fill()
+
+	textColor := color.RGBA{154, 154, 154, 255}
+	if luminance(barColor) > 0.5 {
+		textColor = color.RGBA{80, 80, 80, 255}
+	}
+
+	centerY := margin + height/2
+	if face != nil {
+		dc.SetFontFace(face)
+	}
+
+	switch style {
+	case "macos":
+		lightRadius := height * 0.22
+		spacing := lightRadius * 3.3
+		x := margin + spacing
+		for _, c := range []color.RGBA{macosClose, macosMinimize, macosMaximize} {
+			dc.SetColor(c)
+			dc.DrawCircle(x, centerY, lightRadius)
+			dc.Fill()
+			x += spacing
+		}
+		if title != "" {
+			dc.SetColor(textColor)
+			dc.DrawStringAnchored(title, margin+width/2, centerY, 0.5, 0.35)
+		}
+
+	case "windows":
+		if title != "" {
+			dc.SetColor(textColor)
+			dc.DrawStringAnchored(title, margin+height*0.5, centerY, 0, 0.35)
+		}
+
+		// Minimize, maximize and close buttons
+		size := height * 0.25
+		lineWidth := math.Max(1, height/28)
+		dc.SetColor(textColor)
+		dc.SetLineWidth(lineWidth)
+		x := margin + width - height*0.75
+		dc.DrawLine(x-size/2, centerY-size/2, x+size/2, centerY+size/2)
+		dc.DrawLine(x-size/2, centerY+size/2, x+size/2, centerY-size/2)
+		dc.Stroke()
+		x -= height * 1.5
+		dc.DrawRectangle(x-size/2, centerY-size/2, size, size)
+		dc.Stroke()
+		x -= height * 1.5
+		dc.DrawLine(x-size/2, centerY, x+size/2, centerY)
+		dc.Stroke()
+
+	case "minimal":
+		if title != "" {
+			dc.SetColor(textColor)
+			dc.DrawStringAnchored(title, margin+width/2, centerY, 0.5, 0.35)
+		}
+	}
+}
+
+// drawCover scales an image to cover dst, cropping the sides or the top and
+// bottom that don't fit
+func drawCover(dst *image.RGBA, src image.Image) {
+	sb, db := src.Bounds(), dst.Bounds()
+	if sb.Empty() {
+		return
+	}
+	scale := math.Max(float64(db.Dx())/float64(sb.Dx()), float64(db.Dy())/float64(sb.Dy()))
+	w, h := float64(db.Dx())/scale, float64(db.Dy())/scale
+	x0 := float64(sb.Min.X) + (float64(sb.Dx())-w)/2
+	y0 := float64(sb.Min.Y) + (float64(sb.Dy())-h)/2
+	crop := image.Rect(int(x0), int(y0), int(math.Ceil(x0+w)), int(math.Ceil(y0+h))).Intersect(sb)
+	xdraw.CatmullRom.Scale(dst, db, src, crop, draw.Over, nil)
+}
+
+// windowShadow builds a blurred alpha mask for the window drop shadow. A
+// see-through window gets no shadow under it.
+func windowShadow(width, height int, margin, winWidth, winHeight, radius float64, seeThrough bool) *image.Alpha {
+	dc := gg.NewContext(width, height)
+	offset := margin / 6
+	drawRoundedRect(dc, margin, margin+offset, winWidth, winHeight, radius)
+	dc.SetColor(color.RGBA{0, 0, 0, 128})
+	dc.Fill()
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	draw.Draw(mask, mask.Bounds(), dc.Image(), image.Point{}, draw.Src)
+	blurAlpha(mask, int(margin/3))
+
+	if seeThrough {
+		window := gg.NewContext(width, height)
+		drawRoundedRect(window, margin, margin, winWidth, winHeight, radius)
+		window.SetColor(color.Black)
+		window.Fill()
+		cutout := window.Image().(*image.RGBA)
+		for i := range mask.Pix {
+			mask.Pix[i] = uint8(int(mask.Pix[i]) * (255 - int(cutout.Pix[4*i+3])) / 255)
+		}
+	}
+	return mask
+}
+
+// blurAlpha approximates a gaussian blur with three box blur passes
+func blurAlpha(img *image.Alpha, radius int) {
+	if radius < 1 {
+		return
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	tmp := make([]uint8, w*h)
+	col := make([]uint8, h)
+	out := make([]uint8, h)
+
+	for pass := 0; pass < 3; pass++ {
+		// Horizontal
+		for y := 0; y < h; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w]
+			boxBlurLine(row, tmp[y*w:y*w+w], radius)
+		}
+		// Vertical
+		for x := 0; x < w; x++ {
+			for y := 0; y < h; y++ {
+				col[y] = tmp[y*w+x]
+			}
+			boxBlurLine(col, out, radius)
+			for y := 0; y < h; y++ {
+				img.Pix[y*img.Stride+x] = out[y]
+			}
+		}
+	}
+}
+
+// boxBlurLine blurs a line of alpha values with a moving average
+func boxBlurLine(src, dst []uint8, radius int) {
+	n := len(src)
+	sum := 0
+	for i := -radius; i <= radius; i++ {
+		sum += int(src[clampInt(i, 0, n-1)])
+	}
+	size := radius*2 + 1
+	for i := 0; i < n; i++ {
+		dst[i] = uint8(sum / size)
+		sum += int(src[clampInt(i+radius+1, 0, n-1)])
+		sum -= int(src[clampInt(i-radius, 0, n-1)])
+	}
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// titleBarColor picks a title bar color that stands out slightly from the
+// background. The bar of a transparent window is dark.
+func titleBarColor(bg color.RGBA) color.RGBA {
+	if bg.A == 0 {
+		bg = color.RGBA{0, 0, 0, 255}
+	}
+	if luminance(bg) > 0.5 {
+		return darkenColor(bg, 0.08)
+	}
+	return lightenColor(bg, 0.1)
+}
+
+// lightenColor makes a color lighter by the given factor (0-1)
+func lightenColor(c color.RGBA, factor float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c.R) + (255-float64(c.R))*factor),
+		G: uint8(float64(c.G) + (255-float64(c.G))*factor),
+		B: uint8(float64(c.B) + (255-float64(c.B))*factor),
+		A: c.A,
+	}
+}
+
+// luminance returns the relative luminance of a color (0-1)
+func luminance(c color.RGBA) float64 {
+	return (0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)) / 255
+}
@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rizkyandriawan/eddie/internal/config"
+	"github.com/rizkyandriawan/eddie/internal/renderer"
+)
+
+// recorder takes screen snapshots at a fixed interval for an animation
+type recorder struct {
+	term     *Terminal
+	mu       *sync.Mutex
+	palette  *config.Palette
+	title    string
+	interval time.Duration
+
+	frames []renderer.Frame
+	last   *ScreenBuffer
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// startRecorder starts recording the terminal screen
+func startRecorder(term *Terminal, mu *sync.Mutex, palette *config.Palette, title string, interval time.Duration) *recorder {
+	rec := &recorder{
+		term:     term,
+		mu:       mu,
+		palette:  palette,
+		title:    title,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(rec.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		rec.snapshot()
+		for {
+			select {
+			case <-ticker.C:
+				rec.snapshot()
+			case <-rec.stop:
+				rec.snapshot()
+				return
+			}
+		}
+	}()
+
+	return rec
+}
+
+// snapshot records the current screen, extending the previous frame if
+// the screen did not change
+func (rec *recorder) snapshot() {
+	rec.mu.Lock()
+	buffer := GetScreenBuffer(rec.term, rec.term.cols, rec.term.rows, rec.palette)
+	rec.mu.Unlock()
+	if buffer.Title == "" {
+		buffer.Title = rec.title
+	}
+
+	if rec.last != nil && sameScreen(rec.last, buffer) {
+		rec.frames[len(rec.frames)-1].Delay += rec.interval
+		return
+	}
+	rec.last = buffer
+	rec.frames = append(rec.frames, renderer.Frame{
+		Buffer: convertToRenderBuffer(buffer),
+		Delay:  rec.interval,
+	})
+}
+
+// Stop stops recording and returns the recorded frames
+func (rec *recorder) Stop() []renderer.Frame {
+	rec.once.Do(func() { close(rec.stop) })
+	<-rec.done
+	return rec.frames
+}
+
+// sameScreen reports whether two screen buffers look the same
+func sameScreen(a, b *ScreenBuffer) bool {
+	if a.Title != b.Title || a.CursorX != b.CursorX || a.CursorY != b.CursorY ||
+		a.CursorVisible != b.CursorVisible || len(a.Lines) != len(b.Lines) {
+		return false
+	}
+	// Images compare by pointer, position and size
+	if !slices.Equal(a.Images, b.Images) {
+		return false
+	}
+	for i := range a.Lines {
+		if len(a.Lines[i].Cells) != len(b.Lines[i].Cells) {
+			return false
+		}
+		for j := range a.Lines[i].Cells {
+			if a.Lines[i].Cells[j] != b.Lines[i].Cells[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
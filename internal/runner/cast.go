@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castHeader is the first line of an asciinema v2 cast file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env"`
+}
+
+// castWriter writes an asciinema v2 cast file
+type castWriter struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	start   time.Time
+	partial []byte
+	closed  bool
+}
+
+// newCastWriter creates a cast file and writes its header
+func newCastWriter(path string, cols, rows int, title, term string) (*castWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &castWriter{
+		f:     f,
+		w:     bufio.NewWriter(f),
+		start: time.Now(),
+	}
+	header := castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: c.start.Unix(),
+		Title:     title,
+		Env: map[string]string{
+			"SHELL": os.Getenv("SHELL"),
+			"TERM":  term,
+		},
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.w.Write(data)
+	c.w.WriteByte('\n')
+	return c, nil
+}
+
+// Output records program output
+func (c *castWriter) Output(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Keep an incomplete UTF-8 sequence for the next event
+	data := append(c.partial, p...)
+	c.partial = nil
+	end := len(data)
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				end = len(data) - i
+			}
+			break
+		}
+	}
+	if end < len(data) {
+		c.partial = append([]byte(nil), data[end:]...)
+	}
+	c.event("o", data[:end])
+}
+
+// Input records input sent to the program
+func (c *castWriter) Input(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.event("i", p)
+}
+
+// event writes a single event line, the caller must hold the lock
+func (c *castWriter) event(kind string, data []byte) {
+	if c.closed || len(data) == 0 {
+		return
+	}
+	line, err := json.Marshal([]interface{}{
+		math.Round(time.Since(c.start).Seconds()*1e6) / 1e6,
+		kind,
+		string(data),
+	})
+	if err != nil {
+		return
+	}
+	c.w.Write(line)
+	c.w.WriteByte('\n')
+}
+
+// Close flushes and closes the cast file
+func (c *castWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.event("o", c.partial)
+	c.closed = true
+	if err := c.w.Flush(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// castInput forwards input to the program and records it in the cast
+type castInput struct {
+	w    io.Writer
+	cast *castWriter
+}
+
+// Write sends input to the program
+func (in castInput) Write(p []byte) (int, error) {
+	// Record first, the program may echo the input right away
+	in.cast.Input(p)
+	return in.w.Write(p)
+}
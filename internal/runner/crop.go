@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"fmt"
+	"image/color"
+	"regexp"
+	"strings"
+
+	"github.com/rizkyandriawan/eddie/internal/config"
+)
+
+// Region is a rectangle of cells, the first and last row and column
+// counted from 1
+type Region struct {
+	Rows [2]int
+	Cols [2]int
+}
+
+// cropRegion returns the part of a screen buffer a crop selects: the rows
+// and columns it names, narrowed to the lines its patterns match, then
+// trimmed. Blank cells are spaces on the background color.
+func cropRegion(sb *ScreenBuffer, crop *config.Crop, background color.RGBA) (Region, error) {
+	top, bottom := 0, len(sb.Lines)-1
+	left, right := 0, sb.Width-1
+	if len(crop.Rows) == 2 {
+		top, bottom = cropRange(crop.Rows, len(sb.Lines))
+	}
+	if len(crop.Cols) == 2 {
+		left, right = cropRange(crop.Cols, sb.Width)
+	}
+
+	if crop.From != "" {
+		row, err := sb.findLine(crop.From, top, bottom)
+		if err != nil {
+			return Region{}, fmt.Errorf("from: %w", err)
+		}
+		top = row
+	}
+	if crop.To != "" {
+		row, err := sb.findLine(crop.To, top, bottom)
+		if err != nil {
+			return Region{}, fmt.Errorf("to: %w", err)
+		}
+		bottom = row
+	}
+
+	if crop.Trim {
+		for bottom > top && sb.blankCells(bottom, bottom, left, right, background) {
+			bottom--
+		}
+		for right > left && sb.blankCells(top, bottom, right, right, background) {
+			right--
+		}
+	}
+
+	if top > bottom || left > right {
+		return Region{}, fmt.Errorf("rows %v and columns %v leave nothing to show", crop.Rows, crop.Cols)
+	}
+	return Region{Rows: [2]int{top + 1, bottom + 1}, Cols: [2]int{left + 1, right + 1}}, nil
+}
+
+// cropRange converts a first and last row or column, counted from 1 or
+// from the end if negative, to indexes within n
+func cropRange(r []int, n int) (first, last int) {
+	index := func(v int) int {
+		if v < 0 {
+			v += n
+		} else {
+			v--
+		}
+		return min(max(v, 0), n-1)
+	}
+	return index(r[0]), index(r[1])
+}
+
+// findLine returns the first line from top to bottom whose text matches a
+// regular expression
+func (sb *ScreenBuffer) findLine(pattern string, top, bottom int) (int, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, err
+	}
+	for row := top; row <= bottom; row++ {
+		if re.MatchString(sb.Lines[row].Text()) {
+			return row, nil
+		}
+	}
+	return 0, fmt.Errorf("no line matches %q", pattern)
+}
+
+// Text returns the characters of a line without trailing spaces
+func (l ScreenLine) Text() string {
+	var b strings.Builder
+	for _, cell := range l.Cells {
+		if cell.Spacer {
+			continue
+		}
+		b.WriteRune(cell.Char)
+		b.WriteString(cell.Combining)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// blankCells reports whether the cells in the rows and columns from top to
+// bottom and left to right show nothing: no characters, colors, lines,
+// images or cursor
+func (sb *ScreenBuffer) blankCells(top, bottom, left, right int, background color.RGBA) bool {
+	for row := top; row <= bottom; row++ {
+		for col := left; col <= right; col++ {
+			c := sb.Lines[row].Cells[col]
+			if c.Char != ' ' || c.Combining != "" || c.Spacer || c.Underline || c.Strikethrough || c.Reverse || c.BG != background {
+				return false
+			}
+			if sb.CursorVisible && row == sb.CursorY && col == sb.CursorX {
+				return false
+			}
+		}
+	}
+	for _, img := range sb.Images {
+		if img.X < float64(right+1) && img.X+img.Width > float64(left) &&
+			img.Y < float64(bottom+1) && img.Y+img.Height > float64(top) {
+			return false
+		}
+	}
+	return true
+}
+
+// Crop returns the part of a screen buffer in a region. Wide characters cut
+// in half are left out.
+func (sb *ScreenBuffer) Crop(region Region) *ScreenBuffer {
+	top, left := region.Rows[0]-1, region.Cols[0]-1
+	width := region.Cols[1] - left
+	height := region.Rows[1] - top
+
+	cropped := &ScreenBuffer{
+		Title:         sb.Title,
+		Width:         width,
+		Height:        height,
+		Lines:         make([]ScreenLine, height),
+		CursorX:       sb.CursorX - left,
+		CursorY:       sb.CursorY - top,
+		CursorVisible: sb.CursorVisible,
+	}
+	if cropped.CursorX < 0 || cropped.CursorX >= width || cropped.CursorY < 0 || cropped.CursorY >= height {
+		cropped.CursorVisible = false
+	}
+
+	for i := range cropped.Lines {
+		cells := append([]ScreenCell(nil), sb.Lines[top+i].Cells[left:left+width]...)
+		if first := &cells[0]; first.Spacer {
+			first.Spacer = false
+		}
+		if last := &cells[width-1]; last.Wide {
+			last.Wide = false
+			last.Char = ' '
+			last.Combining = ""
+		}
+		cropped.Lines[i].Cells = cells
+	}
+
+	for _, img := range sb.Images {
+		img.X -= float64(left)
+		img.Y -= float64(top)
+		if img.X+img.Width > 0 && img.X < float64(width) && img.Y+img.Height > 0 && img.Y < float64(height) {
+			cropped.Images = append(cropped.Images, img)
+		}
+	}
+	return cropped
+}
@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"image"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// inlineImage is an image the program drew with sixel or the kitty graphics
+// protocol. It moves with the lines it is on as the screen scrolls.
+type inlineImage struct {
+	img           image.Image
+	x, y          float64 // top-left corner in cells, y is negative when scrolled partly off the top
+	width, height float64 // size in cells
+	z             int     // kitty z-index, negative is below the text
+	id, placement uint32  // kitty image and placement ids, 0 for sixel images
+}
+
+// SetCellSize sets the size of a cell in pixels, which decides how many
+// cells an image covers
+func (t *Terminal) SetCellSize(width, height int) {
+	t.cellWidth, t.cellHeight = max(width, 1), max(height, 1)
+}
+
+// SetReplyWriter sets where answers to the program's queries go, usually
+// the pty
+func (t *Terminal) SetReplyWriter(w io.Writer) {
+	t.reply = w
+}
+
+// handleDCS handles device control strings: sixel images
+func (t *Terminal) handleDCS(seq string) {
+	// Parameters, then q for sixel
+	end := strings.IndexFunc(seq, func(r rune) bool { return r >= 0x40 && r <= 0x7e })
+	if end == -1 || seq[end] != 'q' {
+		return
+	}
+	img := decodeSixel(seq[end+1:])
+	if img == nil {
+		return
+	}
+
+	t.flush()
+	size := img.Bounds().Size()
+	im := &inlineImage{
+		img:    img,
+		width:  float64(size.X) / float64(t.cellWidth),
+		height: float64(size.Y) / float64(t.cellHeight),
+	}
+
+	// In sixel display mode images go in the top-left corner and the
+	// cursor stays where it is
+	if t.sixelDisplayMode {
+		t.images = append(t.images, im)
+		return
+	}
+
+	// Otherwise they go at the cursor, which moves below them, scrolling
+	// the screen if they don't fit
+	cur := t.Cursor()
+	im.x, im.y = float64(cur.X), float64(cur.Y)
+	t.images = append(t.images, im)
+	for i := 0; i < int(math.Ceil(im.height)); i++ {
+		t.newline()
+	}
+}
+
+// moveImages moves the images on the lines from orig to the bottom of the
+// scrolling region down n lines, up if n is negative, and drops those that
+// leave it. Images starting above the lines stay, unless the lines start at
+// the top of the screen and the image already scrolled partly off it. Off
+// the top of the main screen, images are kept as long as the scrollback.
+func (t *Terminal) moveImages(orig, n int) {
+	top, bottom := float64(orig), float64(t.bottom+1)
+	if orig == 0 && n < 0 && !t.altScreen {
+		top -= float64(len(t.scrollback))
+	}
+	t.images = slices.DeleteFunc(t.images, func(im *inlineImage) bool {
+		if im.y >= bottom || im.y+im.height <= top || im.y < float64(orig) && orig > 0 {
+			return false
+		}
+		im.y += float64(n)
+		return im.y >= bottom || im.y+im.height <= top
+	})
+}
+
+// removeImages removes the images a function matches and returns them
+func (t *Terminal) removeImages(match func(im *inlineImage) bool) []*inlineImage {
+	var removed []*inlineImage
+	t.images = slices.DeleteFunc(t.images, func(im *inlineImage) bool {
+		if match(im) {
+			removed = append(removed, im)
+			return true
+		}
+		return false
+	})
+	return removed
+}
+
+// screenImages returns the images on the screen and the last lines of the
+// scrollback, those with a lower z-index first. Positions count from the
+// first scrollback line.
+func (t *Terminal) screenImages(scrollback int) []ScreenImage {
+	var images []ScreenImage
+	above := float64(scrollback)
+	for _, im := range t.images {
+		if im.y+im.height <= -above || im.y >= float64(t.rows) || im.x >= float64(t.cols) {
+			continue
+		}
+		images = append(images, ScreenImage{
+			Image:  im.img,
+			X:      im.x,
+			Y:      im.y + above,
+			Width:  im.width,
+			Height: im.height,
+			Z:      im.z,
+		})
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Z < images[j].Z })
+	return images
+}
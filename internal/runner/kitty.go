@@ -0,0 +1,398 @@
+package runner
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// kittyCommand is a kitty graphics protocol command, ESC _ G keys ; payload ESC \
+type kittyCommand struct {
+	action        byte // a: t transmit, T transmit and display, p display, d delete, q query
+	format        int  // f: 24 RGB, 32 RGBA or 100 PNG
+	medium        byte // t: d direct, f file, t temporary file, s shared memory
+	compression   byte // o: z for zlib
+	delete        byte // d: what to delete
+	more          bool // m: more chunks follow
+	quiet         int  // q: 1 no OK responses, 2 no responses at all
+	id, number    uint32
+	placement     uint32
+	width, height int // s, v: size of RGB and RGBA data
+	srcX, srcY    int // x, y, w, h: the part of the image to display
+	srcW, srcH    int
+	offsetX       int // X, Y: pixel offset in the first cell
+	offsetY       int
+	cols, rows    int // c, r: size to display in cells
+	z             int
+	noMove        bool // C=1: don't move the cursor
+	virtual       bool // U=1: displayed with Unicode placeholders
+	payload       string
+}
+
+// parseKittyCommand parses the part of a kitty graphics command after the G
+func parseKittyCommand(seq string) kittyCommand {
+	c := kittyCommand{action: 't', format: 32, medium: 'd'}
+	keys, payload, _ := strings.Cut(seq, ";")
+	c.payload = payload
+	for _, kv := range strings.Split(keys, ",") {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || len(key) != 1 || value == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(value)
+		switch key[0] {
+		case 'a':
+			c.action = value[0]
+		case 'f':
+			c.format = n
+		case 't':
+			c.medium = value[0]
+		case 'o':
+			c.compression = value[0]
+		case 'd':
+			c.delete = value[0]
+		case 'm':
+			c.more = n == 1
+		case 'q':
+			c.quiet = n
+		case 'i':
+			c.id = uint32(n)
+		case 'I':
+			c.number = uint32(n)
+		case 'p':
+			c.placement = uint32(n)
+		case 's':
+			c.width = n
+		case 'v':
+			c.height = n
+		case 'x':
+			c.srcX = n
+		case 'y':
+			c.srcY = n
+		case 'w':
+			c.srcW = n
+		case 'h':
+			c.srcH = n
+		case 'X':
+			c.offsetX = n
+		case 'Y':
+			c.offsetY = n
+		case 'c':
+			c.cols = n
+		case 'r':
+			c.rows = n
+		case 'z':
+			c.z = n
+		case 'C':
+			c.noMove = n == 1
+		case 'U':
+			c.virtual = n == 1
+		}
+	}
+	return c
+}
+
+// handleKitty runs a kitty graphics command
+func (t *Terminal) handleKitty(seq string) {
+	c := parseKittyCommand(seq)
+
+	// Chunked data: the first chunk has the keys, the last one has m=0
+	if c.more || t.kittyChunk != nil {
+		if t.kittyChunk == nil {
+			first := c
+			t.kittyChunk = &first
+		} else {
+			t.kittyChunk.payload += c.payload
+		}
+		if c.more {
+			return
+		}
+		c = *t.kittyChunk
+		t.kittyChunk = nil
+	}
+
+	if c.id != 0 && c.number != 0 {
+		t.kittyReply(c, "EINVAL:both image id and number specified")
+		return
+	}
+
+	switch c.action {
+	case 't', 'T', 'q':
+		img, err := c.decode()
+		if err != nil {
+			t.kittyReply(c, err.Error())
+			return
+		}
+		if c.action == 'q' {
+			t.kittyReply(c, "OK")
+			return
+		}
+		// Only images with an id or number are kept to display again
+		named := c.id != 0 || c.number != 0
+		c.id = t.kittyImageID(c)
+		if named {
+			t.kittyImages[c.id] = img
+		}
+		if c.action == 'T' {
+			t.placeKittyImage(c, img)
+		}
+		if named {
+			t.kittyReply(c, "OK")
+		}
+	case 'p':
+		if c.id == 0 {
+			c.id = t.kittyNumbers[c.number]
+		}
+		img, ok := t.kittyImages[c.id]
+		if !ok {
+			t.kittyReply(c, "ENOENT:image not found")
+			return
+		}
+		t.placeKittyImage(c, img)
+		t.kittyReply(c, "OK")
+	case 'd':
+		t.deleteKittyImages(c)
+	}
+}
+
+// kittyImageID returns the id to store a transmitted image under: its
+// own, or a new one for images sent with a number or neither
+func (t *Terminal) kittyImageID(c kittyCommand) uint32 {
+	if c.id != 0 {
+		return c.id
+	}
+	t.nextImageID++
+	id := t.nextImageID | 1<<31 // stay clear of the ids programs choose
+	if c.number != 0 {
+		t.kittyNumbers[c.number] = id
+	}
+	return id
+}
+
+// placeKittyImage displays an image at the cursor and moves the cursor
+// after it, unless asked not to
+func (t *Terminal) placeKittyImage(c kittyCommand, img image.Image) {
+	if c.virtual {
+		return // Unicode placeholders aren't supported
+	}
+
+	// Part of the image to display
+	bounds := img.Bounds()
+	src := image.Rect(c.srcX, c.srcY, c.srcX+c.srcW, c.srcY+c.srcH).Add(bounds.Min)
+	if c.srcW == 0 {
+		src.Max.X = bounds.Max.X
+	}
+	if c.srcH == 0 {
+		src.Max.Y = bounds.Max.Y
+	}
+	src = src.Intersect(bounds)
+	if src.Empty() {
+		return
+	}
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		img = sub.SubImage(src)
+	}
+
+	// Size in cells, scaled to the given columns and rows with the aspect
+	// ratio kept if only one of them is given
+	cw, ch := float64(t.cellWidth), float64(t.cellHeight)
+	width, height := float64(src.Dx())/cw, float64(src.Dy())/ch
+	switch {
+	case c.cols > 0 && c.rows > 0:
+		width, height = float64(c.cols), float64(c.rows)
+	case c.cols > 0:
+		width, height = float64(c.cols), float64(c.cols)*height/width
+	case c.rows > 0:
+		width, height = float64(c.rows)*width/height, float64(c.rows)
+	}
+
+	// Placing an image again moves it
+	if c.placement != 0 {
+		t.removeImages(func(im *inlineImage) bool {
+			return im.id == c.id && im.placement == c.placement
+		})
+	}
+
+	t.flush()
+	cur := t.Cursor()
+	im := &inlineImage{
+		img:       img,
+		x:         float64(cur.X) + float64(min(max(c.offsetX, 0), t.cellWidth-1))/cw,
+		y:         float64(cur.Y) + float64(min(max(c.offsetY, 0), t.cellHeight-1))/ch,
+		width:     width,
+		height:    height,
+		z:         c.z,
+		id:        c.id,
+		placement: c.placement,
+	}
+	t.images = append(t.images, im)
+	if c.noMove {
+		return
+	}
+
+	// The cursor goes right of the image, on its last row
+	rows := int(math.Ceil(im.y + height - float64(cur.Y)))
+	for i := 1; i < rows; i++ {
+		t.newline()
+	}
+	fmt.Fprintf(t.Terminal, "\x1b[%dC", int(math.Ceil(im.x+width-float64(cur.X))))
+}
+
+// deleteKittyImages removes placements, and with an upper case d key the
+// images they show. Deletion by position only supports the cursor cell.
+func (t *Terminal) deleteKittyImages(c kittyCommand) {
+	var match func(im *inlineImage) bool
+	id := c.id
+	switch c.delete {
+	case 0, 'a', 'A':
+		match = func(im *inlineImage) bool { return im.id != 0 }
+	case 'i', 'I', 'n', 'N':
+		if c.delete == 'n' || c.delete == 'N' {
+			id = t.kittyNumbers[c.number]
+		}
+		match = func(im *inlineImage) bool {
+			return im.id == id && (c.placement == 0 || im.placement == c.placement)
+		}
+	case 'c', 'C':
+		t.flush()
+		cur := t.Cursor()
+		x, y := float64(cur.X), float64(cur.Y)
+		match = func(im *inlineImage) bool {
+			return im.id != 0 && im.x < x+1 && im.x+im.width > x && im.y < y+1 && im.y+im.height > y
+		}
+	default:
+		return
+	}
+
+	removed := t.removeImages(match)
+	if c.delete >= 'A' && c.delete <= 'Z' {
+		for _, im := range removed {
+			delete(t.kittyImages, im.id)
+		}
+		if c.delete == 'I' || c.delete == 'N' {
+			delete(t.kittyImages, id)
+		}
+	}
+}
+
+// kittyReply answers a command that named its image, as kitty does, unless
+// it asked to stay quiet
+func (t *Terminal) kittyReply(c kittyCommand, msg string) {
+	if t.reply == nil || c.id == 0 && c.number == 0 {
+		return
+	}
+	if c.quiet >= 2 || c.quiet == 1 && msg == "OK" {
+		return
+	}
+	keys := fmt.Sprintf("i=%d", c.id)
+	if c.number != 0 {
+		keys += fmt.Sprintf(",I=%d", c.number)
+	}
+	if c.placement != 0 {
+		keys += fmt.Sprintf(",p=%d", c.placement)
+	}
+	fmt.Fprintf(t.reply, "\x1b_G%s;%s\x1b\\", keys, msg)
+}
+
+// decode returns the image a transmit command carries. Errors are kitty
+// error responses.
+func (c kittyCommand) decode() (image.Image, error) {
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(c.payload, "="))
+	if err != nil {
+		return nil, errors.New("EINVAL:payload is not base64")
+	}
+
+	switch c.medium {
+	case 'd':
+	case 'f', 't', 's':
+		if data, err = readKittyFile(c.medium, string(data)); err != nil {
+			return nil, fmt.Errorf("EBADF:%v", err)
+		}
+	default:
+		return nil, fmt.Errorf("EINVAL:unsupported transmission medium %q", c.medium)
+	}
+
+	if c.compression == 'z' {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("EINVAL:%v", err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("EINVAL:%v", err)
+		}
+	}
+
+	switch c.format {
+	case 100:
+		cfg, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("EBADPNG:%v", err)
+		}
+		if cfg.Width > maxImageSize || cfg.Height > maxImageSize {
+			return nil, errors.New("EINVAL:image too large")
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("EBADPNG:%v", err)
+		}
+		return img, nil
+	case 24, 32:
+		if c.width <= 0 || c.height <= 0 || c.width > maxImageSize || c.height > maxImageSize {
+			return nil, errors.New("EINVAL:invalid image size")
+		}
+		bpp := c.format / 8
+		if len(data) < c.width*c.height*bpp {
+			return nil, errors.New("ENODATA:insufficient image data")
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, c.width, c.height))
+		for i := 0; i < c.width*c.height; i++ {
+			copy(img.Pix[i*4:i*4+3], data[i*bpp:i*bpp+3])
+			img.Pix[i*4+3] = 255
+			if bpp == 4 {
+				img.Pix[i*4+3] = data[i*4+3]
+			}
+		}
+		return img, nil
+	}
+	return nil, fmt.Errorf("EINVAL:unsupported format %d", c.format)
+}
+
+// readKittyFile reads image data sent as a file, a temporary file or a
+// shared memory object. The last two are deleted once read, temporary
+// files only if the name says they were made for this.
+func readKittyFile(medium byte, name string) ([]byte, error) {
+	if medium == 's' {
+		name = filepath.Join("/dev/shm", filepath.Base(name))
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if medium == 's' || medium == 't' && isTempFile(name) && strings.Contains(filepath.Base(name), "tty-graphics-protocol") {
+		os.Remove(name)
+	}
+	return data, nil
+}
+
+// isTempFile reports whether a file is in a directory for temporary files
+func isTempFile(name string) bool {
+	dir := filepath.Dir(filepath.Clean(name))
+	for _, tmp := range []string{os.TempDir(), "/tmp", "/dev/shm"} {
+		if dir == filepath.Clean(tmp) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,16 +2,15 @@ package runner
 
 import (
 	"fmt"
-	"image/color"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/creack/pty"
-	"github.com/hinshun/vt10x"
 	"github.com/rizkyandriawan/eddie/internal/config"
 	"github.com/rizkyandriawan/eddie/internal/renderer"
 )
@@ -19,24 +18,36 @@ import (
 // Runner runs Claude Code sessions and captures output
 type Runner struct {
 	config   *config.Config
+	palette  config.Palette
 	renderer *renderer.Renderer
 }
 
 // NewRunner creates a new runner
-func NewRunner(cfg *config.Config) *Runner {
+func NewRunner(cfg *config.Config) (*Runner, error) {
+	palette, _ := cfg.Theme.Palette() // validated by config.Load
+	rend, err := renderer.NewRenderer(cfg.Theme)
+	if err != nil {
+		return nil, err
+	}
 	return &Runner{
 		config:   cfg,
-		renderer: renderer.NewRenderer(cfg.Theme),
-	}
+		palette:  palette,
+		renderer: rend,
+	}, nil
 }
 
 // Screenshot represents a captured screenshot
 type Screenshot struct {
 	Name        string
 	Filename    string
+	Files       []string // all rendered files, one per output format
 	Description string
 	Prompt      string
 	WaitMs      int
+	Width       int // image size in physical pixels
+	Height      int
+	Links       []string // OSC 8 hyperlink targets on the screen
+	Crop        *Region  // part of the screen shown, nil if not cropped
 }
 
 // SessionResult holds the results of a session
@@ -45,6 +56,8 @@ type SessionResult struct {
 	Description string
 	Cwd         string
 	Screenshots []Screenshot
+	Animation   string // animated GIF of the session, if enabled
+	Cast        string // asciinema cast of the session, if enabled
 	Error       error
 }
 
@@ -68,7 +81,10 @@ func (r *Runner) RunSession(session config.Session) (*SessionResult, error) {
 	// Create virtual terminal
 	cols := r.config.Terminal.Width
 	rows := r.config.Terminal.Height
-	term := vt10x.New(vt10x.WithSize(cols, rows))
+	term := NewTerminal(cols, rows)
+	cellWidth, cellHeight := r.renderer.CellSize()
+	term.SetCellSize(cellWidth, cellHeight)
+	term.SetScrollback(scrollbackLines(session.Prompts))
 
 	// Determine command to run
 	cmdStr := session.Command
@@ -81,14 +97,38 @@ func (r *Runner) RunSession(session config.Session) (*SessionResult, error) {
 	cmd.Dir = session.Cwd
 	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
 
+	// The pixel size lets programs size the images they draw
 	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{
 		Rows: uint16(rows),
 		Cols: uint16(cols),
+		X:    uint16(cols * cellWidth),
+		Y:    uint16(rows * cellHeight),
 	})
 	if err != nil {
 		return result, fmt.Errorf("failed to start PTY: %w", err)
 	}
 	defer ptmx.Close()
+	term.SetReplyWriter(ptmx)
+
+	// Record the session as an asciinema cast
+	var input io.Writer = ptmx
+	var cast *castWriter
+	if session.Cast.Enabled {
+		title := session.Description
+		if title == "" {
+			title = session.Name
+		}
+		filename := session.Name + ".cast"
+		cast, err = newCastWriter(filepath.Join(r.config.Output, filename), cols, rows, title, "xterm-256color")
+		if err != nil {
+			return result, fmt.Errorf("failed to create cast: %w", err)
+		}
+		defer cast.Close()
+		if session.Cast.Input {
+			input = castInput{w: ptmx, cast: cast}
+		}
+		result.Cast = filename
+	}
 
 	// Channel to signal process exit
 	done := make(chan struct{})
@@ -106,9 +146,19 @@ func (r *Runner) RunSession(session config.Session) (*SessionResult, error) {
 			mu.Lock()
 			term.Write(buf[:n])
 			mu.Unlock()
+			if cast != nil {
+				cast.Output(buf[:n])
+			}
 		}
 	}()
 
+	// Record the whole session for an animation
+	var rec *recorder
+	if session.Animate.Enabled {
+		rec = startRecorder(term, &mu, &r.palette, session.Description, time.Duration(session.Animate.Delay)*time.Millisecond)
+		defer rec.Stop()
+	}
+
 	// Process each prompt
 	for i, prompt := range session.Prompts {
 		// Determine capture name
@@ -140,7 +190,12 @@ func (r *Runner) RunSession(session config.Session) (*SessionResult, error) {
 		// Send input or keystroke (after wait)
 		if prompt.Input != "" {
 			// Send the text input (without automatic newline)
-			_, err := ptmx.Write([]byte(prompt.Input))
+			var err error
+			if rec != nil {
+				err = typeInput(input, prompt.Input, time.Duration(session.Animate.Typing)*time.Millisecond)
+			} else {
+				_, err = input.Write([]byte(prompt.Input))
+			}
 			if err != nil {
 				return result, fmt.Errorf("failed to send input: %w", err)
 			}
@@ -148,7 +203,7 @@ func (r *Runner) RunSession(session config.Session) (*SessionResult, error) {
 		if prompt.Key != "" {
 			// Send keystroke
 			keyBytes := keyToBytes(prompt.Key)
-			_, err := ptmx.Write(keyBytes)
+			_, err := input.Write(keyBytes)
 			if err != nil {
 				return result, fmt.Errorf("failed to send key: %w", err)
 			}
@@ -161,33 +216,73 @@ func (r *Runner) RunSession(session config.Session) (*SessionResult, error) {
 
 		// Capture screenshot
 		if prompt.Capture {
-			outputPath := fmt.Sprintf("%s/%s.png", r.config.Output, captureName)
-
 			// Get screen buffer with colors from virtual terminal
 			mu.Lock()
-			defaultFG := parseHexColor(r.config.Theme.Foreground)
-			defaultBG := parseHexColor(r.config.Theme.Background)
-			screenBuffer := GetScreenBuffer(term, cols, rows, defaultFG, defaultBG)
+			var screenBuffer *ScreenBuffer
+			if prompt.CaptureScrollback.Enabled {
+				screenBuffer = GetScrollbackBuffer(term, cols, rows, &r.palette, prompt.CaptureScrollback.Lines)
+			} else {
+				screenBuffer = GetScreenBuffer(term, cols, rows, &r.palette)
+			}
 			mu.Unlock()
 
+			var crop *Region
+			if prompt.Crop != nil {
+				region, err := cropRegion(screenBuffer, prompt.Crop, r.palette.Background)
+				if err != nil {
+					return result, fmt.Errorf("failed to crop screenshot %s: %w", captureName, err)
+				}
+				screenBuffer = screenBuffer.Crop(region)
+				crop = &region
+			}
+			if screenBuffer.Title == "" {
+				screenBuffer.Title = session.Description
+			}
+
 			// Convert to renderer's ScreenBuffer type
 			renderBuffer := convertToRenderBuffer(screenBuffer)
 
-			err := r.renderer.RenderBuffer(renderBuffer, outputPath)
+			formats := prompt.Format
+			if len(formats) == 0 {
+				formats = r.config.Format
+			}
+			files, err := r.renderCapture(renderBuffer, captureName, formats)
 			if err != nil {
 				return result, fmt.Errorf("failed to render screenshot: %w", err)
 			}
 
+			width, height := r.renderer.Size(renderBuffer)
 			result.Screenshots = append(result.Screenshots, Screenshot{
 				Name:        captureName,
-				Filename:    captureName + ".png",
+				Filename:    files[0],
+				Files:       files,
 				Description: session.Description,
 				Prompt:      prompt.Input,
 				WaitMs:      prompt.Wait,
+				Width:       width,
+				Height:      height,
+				Links:       screenBuffer.Links(),
+				Crop:        crop,
 			})
+		}
+	}
 
-			fmt.Printf("  Captured: %s\n", outputPath)
+	if rec != nil {
+		frames := rec.Stop()
+		filename := session.Name + ".gif"
+		outputPath := filepath.Join(r.config.Output, filename)
+		if err := r.renderer.RenderGIF(frames, outputPath, session.Animate.Loop); err != nil {
+			return result, fmt.Errorf("failed to render animation: %w", err)
 		}
+		result.Animation = filename
+		fmt.Printf("  Animated: %s\n", outputPath)
+	}
+
+	if cast != nil {
+		if err := cast.Close(); err != nil {
+			return result, fmt.Errorf("failed to write cast: %w", err)
+		}
+		fmt.Printf("  Recorded: %s\n", filepath.Join(r.config.Output, result.Cast))
 	}
 
 	// Send Ctrl+C to exit Claude Code
@@ -205,6 +300,48 @@ func (r *Runner) RunSession(session config.Session) (*SessionResult, error) {
 	return result, nil
 }
 
+// scrollbackLines returns how many lines of scrollback the captures of a
+// session need
+func scrollbackLines(prompts []config.Prompt) int {
+	lines := 0
+	for _, prompt := range prompts {
+		switch {
+		case !prompt.CaptureScrollback.Enabled:
+		case prompt.CaptureScrollback.Lines == 0:
+			return MaxScrollback
+		default:
+			lines = max(lines, prompt.CaptureScrollback.Lines)
+		}
+	}
+	return lines
+}
+
+// renderCapture renders a screen buffer to each output format and
+// returns the names of the written files
+func (r *Runner) renderCapture(buffer *renderer.ScreenBuffer, captureName string, formats []string) ([]string, error) {
+	var files []string
+	for _, format := range formats {
+		filename := captureName + "." + format
+		outputPath := filepath.Join(r.config.Output, filename)
+
+		var err error
+		switch format {
+		case "svg":
+			err = r.renderer.RenderSVG(buffer, outputPath, r.config.SVG.EmbedFont)
+		case "html":
+			err = r.renderer.RenderHTML(buffer, outputPath, r.config.HTML.Style == "class")
+		default:
+			err = r.renderer.RenderBuffer(buffer, outputPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, filename)
+		fmt.Printf("  Captured: %s\n", outputPath)
+	}
+	return files, nil
+}
 
 // RunAll runs all sessions
 func (r *Runner) RunAll() ([]SessionResult, error) {
@@ -226,7 +363,7 @@ func (r *Runner) RunAll() ([]SessionResult, error) {
 }
 
 // waitUntilScreen waits until the text appears on the virtual terminal screen
-func waitUntilScreen(term vt10x.Terminal, mu *sync.Mutex, text string, timeout time.Duration) error {
+func waitUntilScreen(term *Terminal, mu *sync.Mutex, text string, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
 		mu.Lock()
@@ -240,6 +377,19 @@ func waitUntilScreen(term vt10x.Terminal, mu *sync.Mutex, text string, timeout t
 	return fmt.Errorf("timeout")
 }
 
+// typeInput sends text one character at a time, like a person typing
+func typeInput(w io.Writer, text string, delay time.Duration) error {
+	for i, ch := range text {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		if _, err := w.Write([]byte(string(ch))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // keyToBytes converts key name to bytes
 func keyToBytes(key string) []byte {
 	key = strings.ToLower(key)
@@ -302,9 +452,24 @@ func CaptureScreen(ptmx *os.File, output io.Writer) error {
 // convertToRenderBuffer converts runner.ScreenBuffer to renderer.ScreenBuffer
 func convertToRenderBuffer(sb *ScreenBuffer) *renderer.ScreenBuffer {
 	rb := &renderer.ScreenBuffer{
-		Width:  sb.Width,
-		Height: sb.Height,
-		Lines:  make([]renderer.ScreenLine, len(sb.Lines)),
+		Title:         sb.Title,
+		Width:         sb.Width,
+		Height:        sb.Height,
+		Lines:         make([]renderer.ScreenLine, len(sb.Lines)),
+		CursorX:       sb.CursorX,
+		CursorY:       sb.CursorY,
+		CursorVisible: sb.CursorVisible,
+	}
+
+	for _, img := range sb.Images {
+		rb.Images = append(rb.Images, renderer.ScreenImage{
+			Image:  img.Image,
+			X:      img.X,
+			Y:      img.Y,
+			Width:  img.Width,
+			Height: img.Height,
+			Z:      img.Z,
+		})
 	}
 
 	for i, line := range sb.Lines {
@@ -313,9 +478,19 @@ func convertToRenderBuffer(sb *ScreenBuffer) *renderer.ScreenBuffer {
 		}
 		for j, cell := range line.Cells {
 			rl.Cells[j] = renderer.ScreenCell{
-				Char: cell.Char,
-				FG:   cell.FG,
-				BG:   cell.BG,
+				Char:          cell.Char,
+				FG:            cell.FG,
+				BG:            cell.BG,
+				Bold:          cell.Bold,
+				Italic:        cell.Italic,
+				Underline:     cell.Underline,
+				Strikethrough: cell.Strikethrough,
+				Reverse:       cell.Reverse,
+				Dim:           cell.Dim,
+				Link:          cell.Link,
+				Wide:          cell.Wide,
+				Spacer:        cell.Spacer,
+				Combining:     cell.Combining,
 			}
 		}
 		rb.Lines[i] = rl
@@ -323,40 +498,3 @@ func convertToRenderBuffer(sb *ScreenBuffer) *renderer.ScreenBuffer {
 
 	return rb
 }
-
-// parseHexColor parses a hex color string like "#1a1a1a"
-func parseHexColor(hex string) color.RGBA {
-	if len(hex) == 0 {
-		return color.RGBA{212, 212, 212, 255} // default gray
-	}
-
-	if hex[0] == '#' {
-		hex = hex[1:]
-	}
-
-	if len(hex) != 6 {
-		return color.RGBA{212, 212, 212, 255}
-	}
-
-	r := hexToByte(hex[0:2])
-	g := hexToByte(hex[2:4])
-	b := hexToByte(hex[4:6])
-
-	return color.RGBA{r, g, b, 255}
-}
-
-func hexToByte(s string) uint8 {
-	var val uint8
-	for _, c := range s {
-		val *= 16
-		switch {
-		case c >= '0' && c <= '9':
-			val += uint8(c - '0')
-		case c >= 'a' && c <= 'f':
-			val += uint8(c - 'a' + 10)
-		case c >= 'A' && c <= 'F':
-			val += uint8(c - 'A' + 10)
-		}
-	}
-	return val
-}
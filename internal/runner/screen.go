@@ -1,16 +1,29 @@
 package runner
 
 import (
+	"image"
 	"image/color"
 
 	"github.com/hinshun/vt10x"
+	"github.com/rizkyandriawan/eddie/internal/config"
 )
 
-// ScreenCell represents a single cell with character and colors
+// ScreenCell represents a single cell with character, colors and attributes
 type ScreenCell struct {
-	Char rune
-	FG   color.RGBA
-	BG   color.RGBA
+	Char          rune
+	FG            color.RGBA
+	BG            color.RGBA
+	Bold          bool
+	Italic        bool
+	Underline     bool
+	Strikethrough bool
+	Reverse       bool
+	Dim           bool
+	Blink         bool
+	Link          string // OSC 8 hyperlink target
+	Wide          bool   // Char also takes up the next cell
+	Spacer        bool   // second cell of a wide character
+	Combining     string // marks and joined runes drawn with Char
 }
 
 // ScreenLine represents a line of cells
@@ -20,112 +33,156 @@ type ScreenLine struct {
 
 // ScreenBuffer represents the entire screen state with colors
 type ScreenBuffer struct {
-	Lines  []ScreenLine
-	Width  int
-	Height int
+	Title         string
+	Lines         []ScreenLine
+	Width         int
+	Height        int
+	CursorX       int
+	CursorY       int
+	CursorVisible bool
+	Images        []ScreenImage // drawn in order, later ones on top
 }
 
-// Default terminal colors (matching vt10x)
-var defaultColors = []color.RGBA{
-	{0, 0, 0, 255},       // 0: Black
-	{205, 49, 49, 255},   // 1: Red
-	{13, 188, 121, 255},  // 2: Green
-	{229, 229, 16, 255},  // 3: Yellow
-	{36, 114, 200, 255},  // 4: Blue
-	{188, 63, 188, 255},  // 5: Magenta
-	{17, 168, 205, 255},  // 6: Cyan
-	{229, 229, 229, 255}, // 7: White
-	{102, 102, 102, 255}, // 8: Bright Black (Gray)
-	{241, 76, 76, 255},   // 9: Bright Red
-	{35, 209, 139, 255},  // 10: Bright Green
-	{245, 245, 67, 255},  // 11: Bright Yellow
-	{59, 142, 234, 255},  // 12: Bright Blue
-	{214, 112, 214, 255}, // 13: Bright Magenta
-	{41, 184, 219, 255},  // 14: Bright Cyan
-	{255, 255, 255, 255}, // 15: Bright White
+// ScreenImage is an image the program drew with sixel or the kitty
+// graphics protocol
+type ScreenImage struct {
+	Image         image.Image
+	X, Y          float64 // top-left corner in cells, Y is negative when scrolled partly off the top
+	Width, Height float64 // size in cells
+	Z             int     // images with a negative Z are drawn below the text
 }
 
-// vt10xColorToRGBA converts vt10x color to RGBA
-func vt10xColorToRGBA(c vt10x.Color, defaultColor color.RGBA) color.RGBA {
-	// vt10x.Color is an int representing color index or RGB
-	colorVal := int(c)
+// Glyph mode bits (mirrors vt10x's unexported attr constants)
+const (
+	attrReverse = 1 << iota
+	attrUnderline
+	attrBold
+	attrGfx
+	attrItalic
+	attrBlink
+	attrWrap
+)
 
-	// Default color (0 usually means default)
-	if colorVal == 0 {
+// vt10xColorToRGBA converts a vt10x color to RGBA: the default color, an
+// index into the 256-color palette or a 24-bit color stored as
+// r<<16 | g<<8 | b. True colors the terminal tracked take precedence, as
+// vt10x can't tell those below 256 from palette indexes.
+func vt10xColorToRGBA(c vt10x.Color, tc trueColor, defaultColor color.RGBA, palette *config.Palette) color.RGBA {
+	switch {
+	case tc.set:
+		return color.RGBA{tc.r, tc.g, tc.b, 255}
+	case c == vt10x.DefaultFG || c == vt10x.DefaultBG || c == vt10x.DefaultCursor:
 		return defaultColor
+	case c < 256:
+		return palette.Color256(int(c))
 	}
+	return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 255}
+}
 
-	// Standard 16 colors (1-16, but stored as 0-15 index)
-	if colorVal >= 1 && colorVal <= 16 {
-		return defaultColors[colorVal-1]
+// GetScreenBuffer extracts the full screen state with colors from vt10x
+func GetScreenBuffer(term *Terminal, cols, rows int, palette *config.Palette) *ScreenBuffer {
+	cur := term.Cursor()
+	buffer := &ScreenBuffer{
+		Title:         term.Title(),
+		Width:         cols,
+		Height:        rows,
+		Lines:         make([]ScreenLine, rows),
+		CursorX:       cur.X,
+		CursorY:       cur.Y,
+		CursorVisible: term.CursorVisible(),
+		Images:        term.screenImages(0),
 	}
 
-	// 256 color palette (17-256)
-	if colorVal >= 17 && colorVal <= 256 {
-		idx := colorVal - 1
-		if idx < 16 {
-			return defaultColors[idx]
-		} else if idx < 232 {
-			// 216 colors (6x6x6 cube)
-			idx -= 16
-			r := uint8((idx / 36) * 51)
-			g := uint8(((idx / 6) % 6) * 51)
-			b := uint8((idx % 6) * 51)
-			return color.RGBA{r, g, b, 255}
-		} else {
-			// 24 grayscale
-			gray := uint8((idx-232)*10 + 8)
-			return color.RGBA{gray, gray, gray, 255}
-		}
+	for row := 0; row < rows; row++ {
+		glyphs, extras := term.line(row)
+		buffer.Lines[row] = screenLine(glyphs, extras, palette)
 	}
 
-	// RGB color (encoded as high bits)
-	if colorVal > 256 {
-		// Try to extract RGB - vt10x might encode it differently
-		r := uint8((colorVal >> 16) & 0xFF)
-		g := uint8((colorVal >> 8) & 0xFF)
-		b := uint8(colorVal & 0xFF)
-		if r > 0 || g > 0 || b > 0 {
-			return color.RGBA{r, g, b, 255}
+	return buffer
+}
+
+// screenLine converts a line of vt10x glyphs and their extra state to cells
+// with colors
+func screenLine(glyphs []vt10x.Glyph, extras []cellExtra, palette *config.Palette) ScreenLine {
+	line := ScreenLine{
+		Cells: make([]ScreenCell, len(glyphs)),
+	}
+	extra := func(col int) cellExtra {
+		if extras == nil {
+			return cellExtra{}
 		}
+		return extras[col]
 	}
 
-	return defaultColor
-}
+	for col, cell := range glyphs {
+		ch := cell.Char
+		if ch == 0 {
+			ch = ' '
+		}
 
-// GetScreenBuffer extracts the full screen state with colors from vt10x
-func GetScreenBuffer(term vt10x.Terminal, cols, rows int, defaultFG, defaultBG color.RGBA) *ScreenBuffer {
-	buffer := &ScreenBuffer{
-		Width:  cols,
-		Height: rows,
-		Lines:  make([]ScreenLine, rows),
-	}
+		// vt10x swaps the colors of reverse video cells when writing them,
+		// swap them back so the renderer can apply the attribute itself
+		cellFG, cellBG := cell.FG, cell.BG
+		if cell.Mode&attrReverse != 0 {
+			cellFG, cellBG = cellBG, cellFG
+		}
 
-	for row := 0; row < rows; row++ {
-		line := ScreenLine{
-			Cells: make([]ScreenCell, cols),
+		e := extra(col)
+
+		// Bold text uses the bright variant of the first 8 colors. vt10x
+		// does this itself, but not in reverse video.
+		bold := cell.Mode&attrBold != 0
+		if bold && cellFG < 8 {
+			cellFG += 8
 		}
 
-		for col := 0; col < cols; col++ {
-			cell := term.Cell(col, row)
+		fg := vt10xColorToRGBA(cellFG, e.fg, palette.Foreground, palette)
+		bg := vt10xColorToRGBA(cellBG, e.bg, palette.Background, palette)
+
+		line.Cells[col] = ScreenCell{
+			Char:          ch,
+			FG:            fg,
+			BG:            bg,
+			Bold:          bold,
+			Italic:        cell.Mode&attrItalic != 0,
+			Underline:     cell.Mode&attrUnderline != 0,
+			Strikethrough: e.strikethrough,
+			Reverse:       cell.Mode&attrReverse != 0,
+			Dim:           e.faint,
+			Blink:         cell.Mode&attrBlink != 0,
+			Link:          e.link,
+			Combining:     e.combining,
+		}
 
-			ch := cell.Char
-			if ch == 0 {
-				ch = ' '
+		// Overwriting either half of a wide character erases it
+		if e.wide {
+			if col+1 < len(glyphs) && extra(col+1).spacer {
+				line.Cells[col].Wide = true
+			} else {
+				line.Cells[col].Char = ' '
+				line.Cells[col].Combining = ""
 			}
+		}
+		if e.spacer && col > 0 && line.Cells[col-1].Wide {
+			line.Cells[col].Spacer = true
+		}
+	}
 
-			fg := vt10xColorToRGBA(cell.FG, defaultFG)
-			bg := vt10xColorToRGBA(cell.BG, defaultBG)
+	return line
+}
 
-			line.Cells[col] = ScreenCell{
-				Char: ch,
-				FG:   fg,
-				BG:   bg,
+// Links returns the OSC 8 hyperlink targets on the screen, each once, in
+// reading order
+func (sb *ScreenBuffer) Links() []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, line := range sb.Lines {
+		for _, cell := range line.Cells {
+			if cell.Link != "" && !seen[cell.Link] {
+				seen[cell.Link] = true
+				links = append(links, cell.Link)
 			}
 		}
-
-		buffer.Lines[row] = line
 	}
-
-	return buffer
+	return links
 }
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rizkyandriawan/eddie/internal/config"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// sgrCases are SGR sequences followed by a character, whose colors are
+// checked against testdata/sgr_colors.golden
+var sgrCases = []string{
+	"",
+	"\x1b[0m",
+
+	// 8 colors and their bright variants
+	"\x1b[30m", "\x1b[31m", "\x1b[32m", "\x1b[33m", "\x1b[34m", "\x1b[35m", "\x1b[36m", "\x1b[37m",
+	"\x1b[90m", "\x1b[91m", "\x1b[92m", "\x1b[93m", "\x1b[94m", "\x1b[95m", "\x1b[96m", "\x1b[97m",
+	"\x1b[40m", "\x1b[41m", "\x1b[42m", "\x1b[43m", "\x1b[44m", "\x1b[45m", "\x1b[46m", "\x1b[47m",
+	"\x1b[100m", "\x1b[101m", "\x1b[107m",
+
+	// Bright on bold
+	"\x1b[1;30m", "\x1b[1;31m", "\x1b[31;1m", "\x1b[1;37m", "\x1b[1;91m", "\x1b[1;41m",
+	"\x1b[1;38;5;1m", "\x1b[1;38;5;9m", "\x1b[1;38;5;196m",
+	"\x1b[1;7;31m", "\x1b[1;31m\x1b[22m",
+
+	// 256 colors
+	"\x1b[38;5;0m", "\x1b[38;5;1m", "\x1b[38;5;15m", "\x1b[38;5;16m", "\x1b[38;5;21m", "\x1b[38;5;46m",
+	"\x1b[38;5;67m", "\x1b[38;5;196m", "\x1b[38;5;231m", "\x1b[38;5;232m", "\x1b[38;5;244m", "\x1b[38;5;255m",
+	"\x1b[48;5;0m", "\x1b[48;5;208m", "\x1b[38;5;300m",
+
+	// True colors, including those vt10x can't tell from palette indexes
+	"\x1b[38;2;255;128;0m", "\x1b[48;2;40;42;54m", "\x1b[38;2;0;0;0m", "\x1b[38;2;0;0;5m",
+	"\x1b[48;2;0;0;1m", "\x1b[1;38;2;0;0;3m", "\x1b[38;2;0;0;200m", "\x1b[38;2;1;0;0m",
+	"\x1b[38;2;0;0;5m\x1b[38;2;300;0;0m", "\x1b[38;2;0;0;5m\x1b[31m", "\x1b[38;2;0;0;5;48;2;0;0;6m",
+
+	// Resets and reverse video
+	"\x1b[31;42m\x1b[39m", "\x1b[31;42m\x1b[49m", "\x1b[31;42m\x1b[0m", "\x1b[38;2;0;0;5m\x1b[m",
+	"\x1b[7m", "\x1b[7;31;42m", "\x1b[7;38;2;0;0;5m",
+}
+
+// TestSGRColors feeds SGR sequences through the terminal and checks the
+// colors of the character written after them. Run with -update to rewrite
+// the golden file.
+func TestSGRColors(t *testing.T) {
+	palette, err := config.Theme{}.Palette()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	for _, seq := range sgrCases {
+		term := NewTerminal(4, 1)
+		term.Write([]byte(seq + "x"))
+		cell := GetScreenBuffer(term, 4, 1, &palette).Lines[0].Cells[0]
+
+		name := strings.ReplaceAll(seq, "\x1b", `\e`)
+		if name == "" {
+			name = "(none)"
+		}
+		attrs := ""
+		if cell.Bold {
+			attrs += " bold"
+		}
+		if cell.Reverse {
+			attrs += " reverse"
+		}
+		fmt.Fprintf(&b, "%-36s fg=%s bg=%s%s\n", name, hexRGBA(cell.FG), hexRGBA(cell.BG), attrs)
+	}
+
+	golden := filepath.Join("testdata", "sgr_colors.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotLines := strings.Split(b.String(), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var got, want string
+		if i < len(gotLines) {
+			got = gotLines[i]
+		}
+		if i < len(wantLines) {
+			want = wantLines[i]
+		}
+		if got != want {
+			t.Errorf("line %d:\n got: %s\nwant: %s", i+1, got, want)
+		}
+	}
+}
+
+// hexRGBA formats a color as "#rrggbb", with the alpha if not opaque
+func hexRGBA(c interface{ RGBA() (r, g, b, a uint32) }) string {
+	r, g, b, a := c.RGBA()
+	s := fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+	if a != 0xffff {
+		s += fmt.Sprintf("%02x", a>>8)
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"slices"
+
+	"github.com/hinshun/vt10x"
+	"github.com/rizkyandriawan/eddie/internal/config"
+)
+
+// MaxScrollback is the most lines that scrolled off the screen a terminal
+// keeps
+const MaxScrollback = 10000
+
+// savedLine is a line that scrolled off the top of the screen
+type savedLine struct {
+	glyphs []vt10x.Glyph
+	extras []cellExtra // nil if no cell has extra state
+}
+
+// SetScrollback sets how many lines that scroll off the top of the screen
+// are kept, at most MaxScrollback. The default 0 keeps none.
+func (t *Terminal) SetScrollback(lines int) {
+	t.maxScrollback = min(max(lines, 0), MaxScrollback)
+}
+
+// line returns the glyphs of a line of the screen and their extra state,
+// nil if no cell has any
+func (t *Terminal) line(y int) ([]vt10x.Glyph, []cellExtra) {
+	glyphs := make([]vt10x.Glyph, t.cols)
+	var extras []cellExtra
+	for x := range glyphs {
+		glyphs[x] = t.Cell(x, y)
+		if e := t.extra(x, y); e != (cellExtra{}) {
+			if extras == nil {
+				extras = make([]cellExtra, t.cols)
+			}
+			extras[x] = e
+		}
+	}
+	return glyphs, extras
+}
+
+// scrollLine scrolls the scrolling region up a line, for a line feed or a
+// wrap on its bottom line. The line leaving the top of the main screen is
+// kept in the scrollback, as vt10x is about to drop it.
+func (t *Terminal) scrollLine() {
+	if t.top == 0 && !t.altScreen && t.maxScrollback > 0 {
+		glyphs, extras := t.line(0)
+		t.scrollback = append(t.scrollback, savedLine{glyphs: glyphs, extras: extras})
+		if excess := len(t.scrollback) - t.maxScrollback; excess > 0 {
+			t.scrollback = slices.Delete(t.scrollback, 0, excess)
+		}
+	}
+	t.scrollUp(t.top, 1)
+}
+
+// GetScrollbackBuffer extracts the screen like GetScreenBuffer, preceded by
+// up to limit lines of the scrollback, all of them if limit is 0
+func GetScrollbackBuffer(term *Terminal, cols, rows int, palette *config.Palette, limit int) *ScreenBuffer {
+	saved := term.scrollback
+	if limit > 0 && len(saved) > limit {
+		saved = saved[len(saved)-limit:]
+	}
+
+	screen := GetScreenBuffer(term, cols, rows, palette)
+	buffer := *screen
+	buffer.Height = len(saved) + rows
+	buffer.Lines = make([]ScreenLine, 0, buffer.Height)
+	for _, line := range saved {
+		buffer.Lines = append(buffer.Lines, screenLine(line.glyphs, line.extras, palette))
+	}
+	buffer.Lines = append(buffer.Lines, screen.Lines...)
+	buffer.CursorY += len(saved)
+	buffer.Images = term.screenImages(len(saved))
+	return &buffer
+}
@@ -0,0 +1,185 @@
+package runner
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// maxImageSize bounds the width and height of inline images in pixels, the
+// same limit kitty uses
+const maxImageSize = 10000
+
+// sixelPalette is the VT340 default palette, registers above 15 start black
+var sixelPalette = [16][3]int{
+	{0, 0, 0}, {20, 20, 80}, {80, 13, 13}, {20, 80, 20},
+	{80, 20, 80}, {20, 80, 80}, {80, 80, 20}, {53, 53, 53},
+	{26, 26, 26}, {33, 33, 60}, {60, 26, 26}, {33, 60, 33},
+	{60, 33, 60}, {33, 60, 60}, {60, 60, 33}, {80, 80, 80},
+}
+
+// sixelImage is a sixel image being decoded, it grows as pixels are set
+type sixelImage struct {
+	rows          [][]color.NRGBA
+	width, height int // raster attributes, the least size of the image
+	registers     [1024]color.NRGBA
+	current       color.NRGBA
+}
+
+// decodeSixel decodes the data of a sixel DCS sequence, the part after the
+// q. Pixels no sixel sets are transparent. It returns nil for an empty image.
+func decodeSixel(data string) *image.NRGBA {
+	s := &sixelImage{}
+	for i, rgb := range sixelPalette {
+		s.registers[i] = percentRGB(rgb[0], rgb[1], rgb[2])
+	}
+	s.current = s.registers[0]
+
+	x, y := 0, 0
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '"':
+			// Raster attributes: aspect ratio (ignored, pixels are square) and size
+			var params []int
+			params, i = sixelParams(data, i+1)
+			if len(params) >= 4 {
+				s.width = min(max(params[2], 0), maxImageSize)
+				s.height = min(max(params[3], 0), maxImageSize)
+			}
+		case c == '#':
+			// Color introducer: select a register, and define it first if
+			// there are more parameters
+			var params []int
+			params, i = sixelParams(data, i+1)
+			reg := params[0] % len(s.registers)
+			if len(params) >= 5 {
+				switch params[1] {
+				case 1:
+					s.registers[reg] = hlsRGB(params[2], params[3], params[4])
+				case 2:
+					s.registers[reg] = percentRGB(params[2], params[3], params[4])
+				}
+			}
+			s.current = s.registers[reg]
+		case c == '!':
+			// Repeat the next sixel
+			var params []int
+			params, i = sixelParams(data, i+1)
+			if i+1 < len(data) && data[i+1] >= '?' && data[i+1] <= '~' {
+				i++
+				n := min(params[0], maxImageSize)
+				s.put(x, y, data[i]-'?', n)
+				x += n
+			}
+		case c == '$':
+			x = 0
+		case c == '-':
+			x = 0
+			y += 6
+		case c >= '?' && c <= '~':
+			s.put(x, y, c-'?', 1)
+			x++
+		}
+	}
+	return s.image()
+}
+
+// sixelParams parses the numeric parameters starting at data[i], at least
+// one, and returns them with the index of their last byte
+func sixelParams(data string, i int) ([]int, int) {
+	params := []int{0}
+	for ; i < len(data); i++ {
+		switch c := data[i]; {
+		case c >= '0' && c <= '9':
+			last := &params[len(params)-1]
+			*last = min(*last*10+int(c-'0'), math.MaxInt32)
+		case c == ';':
+			params = append(params, 0)
+		default:
+			return params, i - 1
+		}
+	}
+	return params, i - 1
+}
+
+// put sets the pixels of a sixel, a column of six bits with the lowest on
+// top, n times from x to the right
+func (s *sixelImage) put(x, y int, bits byte, n int) {
+	if bits == 0 || x >= maxImageSize || y >= maxImageSize {
+		return
+	}
+	n = min(n, maxImageSize-x)
+	for bit := 0; bit < 6 && y+bit < maxImageSize; bit++ {
+		if bits&(1<<bit) == 0 {
+			continue
+		}
+		for len(s.rows) <= y+bit {
+			s.rows = append(s.rows, nil)
+		}
+		row := s.rows[y+bit]
+		if len(row) < x+n {
+			row = append(row, make([]color.NRGBA, x+n-len(row))...)
+		}
+		for i := x; i < x+n; i++ {
+			row[i] = s.current
+		}
+		s.rows[y+bit] = row
+	}
+}
+
+// image returns the decoded image, at least as large as its raster
+// attributes
+func (s *sixelImage) image() *image.NRGBA {
+	width, height := s.width, max(s.height, len(s.rows))
+	for _, row := range s.rows {
+		width = max(width, len(row))
+	}
+	if width == 0 || height == 0 {
+		return nil
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y, row := range s.rows {
+		for x, c := range row {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// percentRGB converts sixel RGB, each component 0 to 100, to a color
+func percentRGB(r, g, b int) color.NRGBA {
+	level := func(v int) uint8 {
+		return uint8((min(max(v, 0), 100)*255 + 50) / 100)
+	}
+	return color.NRGBA{level(r), level(g), level(b), 255}
+}
+
+// hlsRGB converts sixel HLS to a color. Sixel hues start at blue, not red:
+// 0 is blue, 120 red and 240 green.
+func hlsRGB(h, l, s int) color.NRGBA {
+	hue := math.Mod(float64(max(h, 0)+240), 360) / 360
+	light := float64(min(max(l, 0), 100)) / 100
+	sat := float64(min(max(s, 0), 100)) / 100
+
+	q := light + sat - light*sat
+	if light < 0.5 {
+		q = light * (1 + sat)
+	}
+	p := 2*light - q
+	channel := func(t float64) uint8 {
+		t = t - math.Floor(t)
+		var v float64
+		switch {
+		case t < 1.0/6:
+			v = p + (q-p)*6*t
+		case t < 1.0/2:
+			v = q
+		case t < 2.0/3:
+			v = p + (q-p)*(2.0/3-t)*6
+		default:
+			v = p
+		}
+		return uint8(math.Round(v * 255))
+	}
+	return color.NRGBA{channel(hue + 1.0/3), channel(hue), channel(hue - 1.0/3), 255}
+}
@@ -0,0 +1,593 @@
+package runner
+
+import (
+	"image"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hinshun/vt10x"
+)
+
+// Cursor state bits (mirrors vt10x's unexported cursor constants)
+const (
+	cursorWrapNext = 1 << 1
+)
+
+// cellExtra holds per-cell state that vt10x does not keep track of
+type cellExtra struct {
+	glyph         vt10x.Glyph // glyph the extra state was recorded for
+	faint         bool
+	strikethrough bool
+	link          string // OSC 8 hyperlink target
+	wide          bool   // the glyph also takes up the next cell
+	spacer        bool   // second cell of a wide glyph
+	combining     string // marks and joined runes following the glyph
+	fg, bg        trueColor
+}
+
+// trueColor is a 24-bit color set with SGR 38;2 or 48;2. vt10x stores these
+// as r<<16 | g<<8 | b, which can't be told apart from palette indexes
+// below 256.
+type trueColor struct {
+	r, g, b uint8
+	set     bool
+}
+
+// Parser states for the output stream
+const (
+	stateGround = iota
+	stateEsc
+	stateEscIntermediate
+	stateCSI
+	stateStr
+	stateStrEsc
+)
+
+// maxStringLength bounds the string sequences kept, inline images included
+const maxStringLength = 64 << 20
+
+// Terminal wraps a vt10x terminal and follows the output stream to track
+// the attributes vt10x drops (faint, strikethrough, hyperlinks, exact true
+// colors), the width of characters, which vt10x takes to be one cell each,
+// and inline images
+type Terminal struct {
+	vt10x.Terminal
+
+	cols, rows int
+	extras     [][]cellExtra
+	altExtras  [][]cellExtra
+
+	// Screen state mirrored from vt10x to move the extras and images with
+	// the lines as they scroll
+	top, bottom int
+	noWrap      bool
+	altScreen   bool
+
+	// Lines that scrolled off the top of the main screen, oldest first
+	scrollback    []savedLine
+	maxScrollback int
+
+	// Stream parser state
+	state   int
+	seq     []byte
+	pending []byte
+	partial []byte
+
+	// Current graphic rendition not handled by vt10x
+	faint         bool
+	strikethrough bool
+	link          string
+	fg, bg        trueColor
+
+	// Inline images, sized by the pixel size of a cell
+	cellWidth, cellHeight int
+	images                []*inlineImage
+	altImages             []*inlineImage
+	sixelDisplayMode      bool
+	kittyImages           map[uint32]image.Image
+	kittyNumbers          map[uint32]uint32
+	kittyChunk            *kittyCommand
+	nextImageID           uint32
+	reply                 io.Writer // answers to queries, nil to drop them
+}
+
+// NewTerminal creates a new tracked virtual terminal
+func NewTerminal(cols, rows int) *Terminal {
+	t := &Terminal{
+		Terminal:     vt10x.New(vt10x.WithSize(cols, rows)),
+		cols:         cols,
+		rows:         rows,
+		extras:       make([][]cellExtra, rows),
+		altExtras:    make([][]cellExtra, rows),
+		bottom:       rows - 1,
+		cellWidth:    10,
+		cellHeight:   20,
+		kittyImages:  map[uint32]image.Image{},
+		kittyNumbers: map[uint32]uint32{},
+	}
+	for i := range t.extras {
+		t.extras[i] = make([]cellExtra, cols)
+		t.altExtras[i] = make([]cellExtra, cols)
+	}
+	return t
+}
+
+// Write feeds program output to the virtual terminal
+func (t *Terminal) Write(p []byte) (int, error) {
+	data := p
+	if len(t.partial) > 0 {
+		data = append(t.partial, p...)
+		t.partial = nil
+	}
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 && !utf8.FullRune(data) {
+			// Incomplete rune at the end of the chunk, keep it for the next write
+			t.partial = append([]byte(nil), data...)
+			break
+		}
+		t.put(r, data[:size])
+		data = data[size:]
+	}
+
+	t.flush()
+	return len(p), nil
+}
+
+// put processes a single rune of the output stream
+func (t *Terminal) put(r rune, raw []byte) {
+	switch t.state {
+	case stateGround:
+		if r == 0x1b {
+			// vt10x catches up first, sequences may depend on the cursor
+			t.flush()
+			t.state = stateEsc
+			t.pending = append(t.pending, raw...)
+			return
+		}
+		if r == '\n' || r == '\v' || r == '\f' {
+			t.followNewline()
+		}
+		if r < 0x20 || r == 0x7f {
+			t.pending = append(t.pending, raw...)
+			return
+		}
+		t.printRune(r, raw)
+
+	case stateEsc:
+		switch r {
+		case 'D', 'E': // IND, NEL
+			t.followNewline()
+		case 'M': // RI
+			t.flush()
+			if t.Cursor().Y == t.top {
+				t.scrollDown(t.top, 1)
+			}
+		case 'c': // RIS
+			t.reset()
+		}
+		t.pending = append(t.pending, raw...)
+		switch r {
+		case '[':
+			t.seq = t.seq[:0]
+			t.state = stateCSI
+		case ']', 'P', '_', '^', 'k':
+			t.seq = append(t.seq[:0], raw...)
+			t.state = stateStr
+		case '(', ')', '*', '+', '#':
+			t.state = stateEscIntermediate
+		case 0x1b:
+			// Stay in escape state
+		default:
+			t.state = stateGround
+		}
+
+	case stateEscIntermediate:
+		t.pending = append(t.pending, raw...)
+		t.state = stateGround
+
+	case stateCSI:
+		t.pending = append(t.pending, raw...)
+		switch {
+		case r == 0x18 || r == 0x1a:
+			t.state = stateGround
+		case r == 0x1b:
+			t.state = stateEsc
+		case r >= 0x40 && r <= 0x7e:
+			t.handleCSI(string(t.seq), r)
+			t.state = stateGround
+		case r >= 0x20:
+			t.seq = append(t.seq, raw...)
+		}
+
+	case stateStr:
+		// vt10x ignores DCS and APC strings, only their end is passed on
+		// so images don't pile up in pending
+		if r < 0x20 || len(t.seq) == 0 || t.seq[0] != 'P' && t.seq[0] != '_' {
+			t.pending = append(t.pending, raw...)
+		}
+		switch r {
+		case 0x07:
+			t.handleString(string(t.seq))
+			t.state = stateGround
+		case 0x1b:
+			t.state = stateStrEsc
+		case 0x18, 0x1a:
+			t.state = stateGround
+		default:
+			if len(t.seq) < maxStringLength {
+				t.seq = append(t.seq, raw...)
+			}
+		}
+
+	case stateStrEsc:
+		if r == '\\' {
+			t.pending = append(t.pending, raw...)
+			t.handleString(string(t.seq))
+			t.state = stateGround
+		} else {
+			// The ESC cut the string off and starts a new sequence, whose
+			// introducer goes through the escape state like any other.
+			// vt10x would drop it, so it gets a second ESC, which cancels
+			// the string there too.
+			t.pending = append(t.pending, 0x1b)
+			t.state = stateEsc
+			t.put(r, raw)
+		}
+	}
+}
+
+// printRune writes a printable rune. Wide runes take up two cells and
+// zero-width runes are added to the character before them.
+func (t *Terminal) printRune(r rune, raw []byte) {
+	t.flush()
+
+	if x, y, ok := t.clusterCell(); ok && t.joinsCluster(r, x, y) {
+		e := t.extra(x, y)
+		e.glyph = t.Cell(x, y)
+		e.combining += string(r)
+		t.extras[y][x] = e
+		return
+	}
+
+	if runeWidth(r) < 2 {
+		t.writeCell(raw, false, false)
+		return
+	}
+
+	// A wide rune that doesn't fit on the line wraps, like in xterm
+	cur := t.Cursor()
+	if cur.X == t.cols-1 && cur.State&cursorWrapNext == 0 {
+		t.writeCell([]byte{' '}, false, false)
+	}
+	t.writeCell(raw, true, false)
+	t.writeCell([]byte{' '}, false, true)
+}
+
+// writeCell writes a rune to vt10x and records the extra state of its cell
+func (t *Terminal) writeCell(raw []byte, wide, spacer bool) {
+	// A rune waiting to wrap on the bottom line scrolls
+	if cur := t.Cursor(); !t.noWrap && cur.State&cursorWrapNext != 0 && cur.Y == t.bottom {
+		t.scrollLine()
+	}
+	t.Terminal.Write(raw)
+
+	x, y, ok := t.lastCell()
+	if !ok {
+		return
+	}
+	e := cellExtra{
+		faint:         t.faint,
+		strikethrough: t.strikethrough,
+		link:          t.link,
+		wide:          wide,
+		spacer:        spacer,
+		fg:            t.fg,
+		bg:            t.bg,
+	}
+	if e != (cellExtra{}) {
+		e.glyph = t.Cell(x, y)
+	}
+	t.extras[y][x] = e
+}
+
+// lastCell returns the cell the last rune was written to: the one left of
+// the cursor, or the one under it if the cursor waits to wrap
+func (t *Terminal) lastCell() (x, y int, ok bool) {
+	cur := t.Cursor()
+	x, y = cur.X, cur.Y
+	if cur.State&cursorWrapNext == 0 {
+		x--
+	}
+	return x, y, x >= 0 && x < t.cols && y >= 0 && y < t.rows
+}
+
+// clusterCell returns the cell holding the character before the cursor,
+// the first cell of a wide character
+func (t *Terminal) clusterCell() (x, y int, ok bool) {
+	x, y, ok = t.lastCell()
+	if ok && x > 0 && t.extra(x, y).spacer {
+		x--
+	}
+	return x, y, ok
+}
+
+// joinsCluster reports whether a rune belongs to the character in a cell:
+// combining marks, runes after a zero-width joiner, skin tone modifiers
+// and the second half of a flag
+func (t *Terminal) joinsCluster(r rune, x, y int) bool {
+	base := t.Cell(x, y).Char
+	if base == 0 {
+		return false
+	}
+	e := t.extra(x, y)
+	switch {
+	case runeWidth(r) == 0:
+		return true
+	case strings.HasSuffix(e.combining, string(zeroWidthJoiner)):
+		return true
+	case isEmojiModifier(r):
+		return e.wide
+	case isRegionalIndicator(r):
+		return isRegionalIndicator(base) && e.combining == ""
+	}
+	return false
+}
+
+// flush forwards buffered control sequences to vt10x
+func (t *Terminal) flush() {
+	if len(t.pending) == 0 {
+		return
+	}
+	t.Terminal.Write(t.pending)
+	t.pending = t.pending[:0]
+}
+
+// handleCSI tracks the parts of a CSI sequence vt10x ignores and the
+// scrolling it does. vt10x hasn't seen the sequence yet.
+func (t *Terminal) handleCSI(params string, final rune) {
+	if strings.ContainsAny(params, "<=>?") {
+		if strings.HasPrefix(params, "?") && (final == 'h' || final == 'l') {
+			t.setPrivateModes(csiArgs(params[1:]), final == 'h')
+		}
+		return
+	}
+
+	args := csiArgs(params)
+	arg := func(i, def int) int {
+		if i < len(args) {
+			return args[i]
+		}
+		return def
+	}
+	switch final {
+	case 'm':
+		t.handleSGR(params)
+	case 'S': // SU
+		t.scrollUp(t.top, arg(0, 1))
+	case 'T': // SD
+		t.scrollDown(t.top, arg(0, 1))
+	case 'L', 'M': // IL, DL
+		if y := t.Cursor().Y; y >= t.top && y <= t.bottom {
+			if final == 'L' {
+				t.scrollDown(y, arg(0, 1))
+			} else {
+				t.scrollUp(y, arg(0, 1))
+			}
+		}
+	case 'r': // DECSTBM
+		top := min(max(arg(0, 1)-1, 0), t.rows-1)
+		bottom := min(max(arg(1, t.rows)-1, 0), t.rows-1)
+		t.top, t.bottom = min(top, bottom), max(top, bottom)
+	case 'J': // ED, clearing the screen removes its images
+		switch arg(0, 0) {
+		case 2:
+			t.removeImages(func(im *inlineImage) bool { return im.y+im.height > 0 })
+		case 3: // and the scrollback, like xterm
+			t.scrollback = nil
+			t.removeImages(func(im *inlineImage) bool { return im.y < 0 })
+		}
+	}
+}
+
+// csiArgs parses CSI parameters like vt10x, up to the first that isn't a number
+func csiArgs(params string) []int {
+	var args []int
+	for _, p := range strings.Split(params, ";") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		args = append(args, n)
+	}
+	return args
+}
+
+// setPrivateModes tracks the DEC private modes that change how lines
+// scroll and where images go
+func (t *Terminal) setPrivateModes(modes []int, set bool) {
+	for _, mode := range modes {
+		switch mode {
+		case 7: // DECAWM
+			t.noWrap = !set
+		case 80: // DECSDM
+			t.sixelDisplayMode = set
+		case 47, 1047, 1049:
+			// Like vt10x: leaving the alternate screen clears it, and
+			// entering it twice swaps back
+			if t.altScreen {
+				t.images = nil
+			}
+			if !set || !t.altScreen {
+				t.extras, t.altExtras = t.altExtras, t.extras
+				t.images, t.altImages = t.altImages, t.images
+				t.altScreen = !t.altScreen
+			}
+		}
+	}
+}
+
+// handleSGR tracks the graphic rendition vt10x ignores
+func (t *Terminal) handleSGR(params string) {
+	if params == "" {
+		params = "0"
+	}
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		code, _ := strconv.Atoi(codes[i])
+		switch {
+		case code == 38 || code == 48 || code == 58:
+			// Extended colors, vt10x keeps the color it had when they're invalid
+			c, ok := trueColor{}, false
+			if i+1 < len(codes) {
+				switch codes[i+1] {
+				case "5":
+					if i+2 < len(codes) {
+						index, _ := strconv.Atoi(codes[i+2])
+						ok = index >= 0 && index <= 255
+					}
+					i += 2
+				case "2":
+					if i+4 < len(codes) {
+						c, ok = parseTrueColor(codes[i+2 : i+5])
+					}
+					i += 4
+				}
+			}
+			switch {
+			case ok && code == 38:
+				t.fg = c
+			case ok && code == 48:
+				t.bg = c
+			}
+		case code >= 30 && code <= 37 || code == 39 || code >= 90 && code <= 97:
+			t.fg = trueColor{}
+		case code >= 40 && code <= 47 || code == 49 || code >= 100 && code <= 107:
+			t.bg = trueColor{}
+		case code == 0:
+			t.faint = false
+			t.strikethrough = false
+			t.fg = trueColor{}
+			t.bg = trueColor{}
+		case code == 2:
+			t.faint = true
+		case code == 22:
+			t.faint = false
+		case code == 9:
+			t.strikethrough = true
+		case code == 29:
+			t.strikethrough = false
+		}
+	}
+}
+
+// parseTrueColor parses the red, green and blue arguments of SGR 38;2 and
+// 48;2 and reports whether they are in range
+func parseTrueColor(args []string) (trueColor, bool) {
+	var rgb [3]int
+	for i, arg := range args {
+		rgb[i], _ = strconv.Atoi(arg)
+		if rgb[i] < 0 || rgb[i] > 255 {
+			return trueColor{}, false
+		}
+	}
+	return trueColor{uint8(rgb[0]), uint8(rgb[1]), uint8(rgb[2]), true}, true
+}
+
+// handleString handles the string sequences vt10x ignores: OSC 8
+// hyperlinks, sixel images and kitty graphics
+func (t *Terminal) handleString(seq string) {
+	switch {
+	case strings.HasPrefix(seq, "P"):
+		t.handleDCS(seq[1:])
+		return
+	case strings.HasPrefix(seq, "_G"):
+		t.handleKitty(seq[2:])
+		return
+	case !strings.HasPrefix(seq, "]8;"):
+		return
+	}
+
+	// OSC 8 ; params ; URI, an empty URI ends the link
+	parts := strings.SplitN(seq[len("]8;"):], ";", 2)
+	if len(parts) != 2 {
+		return
+	}
+	t.link = parts[1]
+}
+
+// followNewline moves the extra state and images up with the lines if a
+// line feed at the cursor scrolls
+func (t *Terminal) followNewline() {
+	t.flush()
+	if t.Cursor().Y == t.bottom {
+		t.scrollLine()
+	}
+}
+
+// newline moves the cursor down a line, scrolling at the bottom
+func (t *Terminal) newline() {
+	t.followNewline()
+	t.Terminal.Write([]byte{'\n'})
+}
+
+// scrollUp moves the extra state and images of the lines from orig to the
+// bottom of the scrolling region up n lines, like vt10x does with the lines
+func (t *Terminal) scrollUp(orig, n int) {
+	n = min(n, t.bottom-orig+1)
+	if n <= 0 {
+		return
+	}
+	lines := t.extras[orig : t.bottom+1]
+	gone := append([][]cellExtra(nil), lines[:n]...)
+	copy(lines, lines[n:])
+	for i, line := range gone {
+		clear(line)
+		lines[len(lines)-n+i] = line
+	}
+	t.moveImages(orig, -n)
+}
+
+// scrollDown moves the extra state and images of the lines from orig to the
+// bottom of the scrolling region down n lines
+func (t *Terminal) scrollDown(orig, n int) {
+	n = min(n, t.bottom-orig+1)
+	if n <= 0 {
+		return
+	}
+	lines := t.extras[orig : t.bottom+1]
+	gone := append([][]cellExtra(nil), lines[len(lines)-n:]...)
+	copy(lines[n:], lines)
+	for i, line := range gone {
+		clear(line)
+		lines[i] = line
+	}
+	t.moveImages(orig, n)
+}
+
+// reset forgets the tracked state, as RIS resets vt10x
+func (t *Terminal) reset() {
+	t.top, t.bottom = 0, t.rows-1
+	t.noWrap, t.altScreen, t.sixelDisplayMode = false, false, false
+	t.faint, t.strikethrough, t.link = false, false, ""
+	t.fg, t.bg = trueColor{}, trueColor{}
+	for _, line := range t.extras {
+		clear(line)
+	}
+	t.images, t.altImages = nil, nil
+	t.kittyImages = map[uint32]image.Image{}
+	t.kittyNumbers = map[uint32]uint32{}
+	t.kittyChunk = nil
+}
+
+// extra returns the extra state of a cell, if it still holds the glyph it was recorded for
+func (t *Terminal) extra(x, y int) cellExtra {
+	e := t.extras[y][x]
+	if e.glyph != t.Cell(x, y) {
+		return cellExtra{}
+	}
+	return e
+}
@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rizkyandriawan/eddie/internal/config"
+)
+
+// TestCutOffString checks that a string sequence cut off by the start of
+// another one doesn't lose the second
+func TestCutOffString(t *testing.T) {
+	palette, err := config.Theme{}.Palette()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		seq    string
+		link   string
+		images int
+	}{
+		{"osc", "\x1b]0;a\x1b]8;;https://example.com\x07hi\x1b]8;;\x07", "https://example.com", 0},
+		{"dcs", "\x1b]0;a\x1bPq#1~~\x1b\\hi", "", 1},
+		{"apc", "\x1b]0;a\x1b_Ga=T,f=24,s=1,v=1;AAAA\x1b\\hi", "", 1},
+		{"cut twice", "\x1b]0;a\x1b]0;b\x1b]8;;https://example.com\x1b\\hi", "https://example.com", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			term := NewTerminal(20, 4)
+			term.Write([]byte(c.seq))
+			buffer := GetScreenBuffer(term, 20, 4, &palette)
+
+			if len(buffer.Images) != c.images {
+				t.Errorf("got %d images, want %d", len(buffer.Images), c.images)
+			}
+			var text, link string
+			for _, line := range buffer.Lines {
+				text += strings.TrimSpace(line.Text())
+				for _, cell := range line.Cells {
+					if cell.Char == 'h' {
+						link = cell.Link
+					}
+				}
+			}
+			if text != "hi" {
+				t.Errorf("got text %q, want %q", text, "hi")
+			}
+			if link != c.link {
+				t.Errorf("got link %q, want %q", link, c.link)
+			}
+		})
+	}
+}
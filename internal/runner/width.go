@@ -0,0 +1,37 @@
+package runner
+
+import (
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+const zeroWidthJoiner = '‍'
+
+// runeWidth returns the number of cells a rune takes up: 0 for combining
+// marks and other zero-width runes, 2 for East Asian wide runes and emoji
+func runeWidth(r rune) int {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1160 && r <= 0x11FF:
+		return 0 // Hangul vowels and final consonants join the syllable before them
+	case isRegionalIndicator(r):
+		return 2 // pairs make up flags
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// isRegionalIndicator reports whether a rune is half of a flag
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isEmojiModifier reports whether a rune is a skin tone modifier
+func isEmojiModifier(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
@@ -17,6 +17,7 @@ func main() {
 	configPath := flag.String("c", "", "Path to YAML config file (required)")
 	outputDir := flag.String("o", "", "Output directory (overrides config)")
 	generateManifest := flag.Bool("manifest", false, "Generate manifest.json")
+	scale := flag.Float64("scale", 0, "Device pixel ratio of images, e.g. 2 (overrides config)")
 	showVersion := flag.Bool("version", false, "Show version")
 	showHelp := flag.Bool("help", false, "Show help")
 
@@ -49,6 +50,22 @@ func main() {
 		cfg.Manifest = true
 	}
 
+	// Override scale factor
+	if *scale < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --scale must be positive\n")
+		os.Exit(2)
+	}
+	if *scale > 0 {
+		cfg.Theme.Scale = *scale
+	}
+
+	// Set up fonts and renderer
+	r, err := runner.NewRunner(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
 	// Create output directory
 	if err := os.MkdirAll(cfg.Output, 0755); err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
@@ -60,7 +77,6 @@ func main() {
 	fmt.Println("====================================")
 	fmt.Printf("Output: %s\n\n", cfg.Output)
 
-	r := runner.NewRunner(cfg)
 	results, err := r.RunAll()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running sessions: %v\n", err)
@@ -96,7 +112,7 @@ func main() {
 }
 
 func printHelp() {
-	fmt.Println(`Eddie - Claude Code Screenshot Tool 🖤
+	fmt.Print(`Eddie - Claude Code Screenshot Tool 🖤
 
 "We are Eddie."
 
@@ -107,6 +123,7 @@ OPTIONS:
     -c <path>       Path to YAML config file (required)
     -o <path>       Output directory (overrides config)
     --manifest      Generate manifest.json
+    --scale <n>     Device pixel ratio of images, e.g. 2 (overrides config)
     --version       Show version
     --help          Show this help
 
@@ -144,6 +161,9 @@ EXAMPLES:
     # With manifest
     eddie -c config.yaml --manifest
 
+    # Retina images
+    eddie -c config.yaml --scale 2
+
 For more information, visit: https://github.com/rizkyandriawan/eddie
 `)
 }